@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	tbot "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"regexp"
+	"strings"
+)
+
+// Account actions passed with AccountCallback
+const (
+	switchAccountAction = "sw"
+	removeAccountAction = "rm"
+	spanAccountsAction  = "span"
+)
+
+// maxAccountNameLength is the longest name in bytes. It keeps callback data such as `acc:rm:<name>` within 64 bytes
+// the Telegram api allows, whatever the script the name is written in
+const maxAccountNameLength = 40
+
+var accountNamePattern = regexp.MustCompile(`^[\p{L}\p{N}_-]{1,20}$`)
+
+func isValidAccountName(name string) bool {
+	return accountNamePattern.MatchString(name) && len(name) <= maxAccountNameLength
+}
+
+// handleAccountsCommand lists linked accounts or starts linking a new one with `/accounts add <name>`.
+func (b *bot) handleAccountsCommand(s *session, args string) error {
+	fields := strings.Fields(args)
+	if len(fields) > 0 && fields[0] == "add" {
+		if len(fields) != 2 {
+			return NewBotError(fmt.Sprintf("Please, specify the name of the account, e.g. /%s add family", AccountsCmd))
+		}
+
+		return b.addAccount(s, fields[1])
+	}
+
+	buf := bytes.Buffer{}
+	buf.WriteString("Linked Yandex accounts:\n")
+	buf.WriteString(fmt.Sprintf("✓ %s (active)\n", s.activeAccountName()))
+
+	rows := make([][]tbot.InlineKeyboardButton, 0)
+	for _, a := range s.accounts {
+		buf.WriteString(fmt.Sprintf("• %s\n", a.name))
+
+		rows = append(rows, tbot.NewInlineKeyboardRow(
+			tbot.NewInlineKeyboardButtonData(fmt.Sprintf("Switch to %s", a.name), fmt.Sprintf("%s:%s:%s", AccountCallback, switchAccountAction, a.name)),
+			tbot.NewInlineKeyboardButtonData(fmt.Sprintf("Remove %s", a.name), fmt.Sprintf("%s:%s:%s", AccountCallback, removeAccountAction, a.name)),
+		))
+	}
+
+	if len(s.accounts) > 0 {
+		text, value := "Show stations of all accounts", "on"
+		if s.spanAccounts {
+			text, value = "Show stations of the active account only", "off"
+		}
+		rows = append(rows, tbot.NewInlineKeyboardRow(
+			tbot.NewInlineKeyboardButtonData(text, fmt.Sprintf("%s:%s:%s", AccountCallback, spanAccountsAction, value)),
+		))
+	}
+
+	buf.WriteString(fmt.Sprintf("\nUse /%s add <name> to link another account.", AccountsCmd))
+
+	msg := tbot.NewMessage(s.chatId, buf.String())
+	if len(rows) > 0 {
+		msg.ReplyMarkup = tbot.NewInlineKeyboardMarkup(rows...)
+	}
+
+	//goland:noinspection GoUnhandledErrorResult
+	b.api.Send(msg)
+
+	return nil
+}
+
+// addAccount sends the login link. The account authorized with it is linked under the name, see completeAuthentication.
+func (b *bot) addAccount(s *session, name string) error {
+	if !isValidAccountName(name) {
+		return NewBotError("Account name may only contain letters, digits, `_` and `-` and must be at most 20 characters long. " +
+			"Please, use a shorter one if it is not written in Latin or Cyrillic letters.")
+	}
+	if s.hasAccount(name) {
+		return NewBotError(fmt.Sprintf("Account `%s` is already linked.", name))
+	}
+
+	err := b.sendLoginUrl(s.key(), fmt.Sprintf("Please, log in with the Yandex account you want to link as `%s` using the link down below. "+
+		"If you are logged in with another account in the browser, use a private window.", name))
+	if err != nil {
+		return err
+	}
+
+	b.sessionProvider.SaveOrUpdate(NewSessionWithPendingAccount(s, name))
+
+	return nil
+}
+
+// handleAccountCallback handles `sw:<name>`, `rm:<name>` and `span:on|off` data.
+func (b *bot) handleAccountCallback(s *session, data string) {
+	action, value, _ := strings.Cut(data, ":")
+
+	switch action {
+	case switchAccountAction:
+		ns, ok := NewSessionWithActiveAccount(s, value)
+		if !ok {
+			b.send(s.chatId, fmt.Sprintf("Account `%s` is not linked anymore.", value))
+			return
+		}
+		// The user has been warned the authorization expires while the account was not active,
+		// so the login link is sent right away
+		relogin := ns.expiryNotifiedAt != nil
+		ns.expiryNotifiedAt = nil
+		b.sessionProvider.SaveOrUpdate(ns)
+
+		b.send(s.chatId, fmt.Sprintf("Switched to account `%s`.", value))
+		if relogin {
+			b.checkTokenExpiry(s.key())
+		}
+	case removeAccountAction:
+		var removed *account
+		for i, a := range s.accounts {
+			if a.name == value {
+				removed = &s.accounts[i]
+			}
+		}
+		if removed == nil {
+			b.send(s.chatId, fmt.Sprintf("Account `%s` is not linked anymore.", value))
+			return
+		}
+
+		text := fmt.Sprintf("Account `%s` has been removed.", value)
+		if err := b.yaClient.revokeOAuthToken(removed.oauthToken.value); err != nil {
+			log.WithError(err).Errorf("Could not revoke OAuth token of account %s for chat %d", value, s.chatId)
+			text += "\nHowever, I could not revoke access to it. You can revoke it manually at https://id.yandex.com/security/apps"
+		}
+
+		if view, ok := NewSessionWithActiveAccount(s, value); ok {
+			b.yaClient.invalidateSmartHomeInfo(view)
+		}
+		b.sessionProvider.SaveOrUpdate(NewSessionWithoutAccount(s, value))
+
+		b.send(s.chatId, text)
+	case spanAccountsAction:
+		span := value == "on"
+		b.sessionProvider.SaveOrUpdate(NewSessionWithSpanAccounts(s, span))
+
+		if span {
+			b.send(s.chatId, "Stations of all linked accounts will be offered when you share a link.")
+		} else {
+			b.send(s.chatId, "Only stations of the active account will be offered when you share a link.")
+		}
+	}
+}
+
+// accountStations holds the stations of a linked account along with the session reaching them through its tokens.
+type accountStations struct {
+	session *session
+	devices []device
+	info    *iotInfo
+}
+
+// spannedAccountStations returns the stations of the linked accounts other than the active one.
+// Accounts whose stations cannot be listed are skipped.
+func (b *bot) spannedAccountStations(s *session) []accountStations {
+	accounts := make([]accountStations, 0, len(s.accounts))
+	for _, a := range s.accounts {
+		as, _ := NewSessionWithActiveAccount(s, a.name)
+		devices, err := b.getYandexStations(as)
+		if err != nil {
+			log.WithError(err).Warnf("Could not get stations of account %s of chat %d", a.name, s.chatId)
+			continue
+		}
+		info, _ := b.yaClient.getYandexSmartHomeInfo(as)
+
+		accounts = append(accounts, accountStations{as, devices, info})
+	}
+
+	return accounts
+}
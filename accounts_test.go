@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestIsValidAccountName(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"family", true},
+		{"work_2-old", true},
+		{"семья", true},
+		{strings.Repeat("я", 20), true},
+		{strings.Repeat("a", 20), true},
+		{"", false},
+		{"my family", false},
+		{"a:b", false},
+		{strings.Repeat("a", 21), false},
+		{strings.Repeat("家", 20), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isValidAccountName(tt.name); got != tt.want {
+				t.Errorf("isValidAccountName() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAccountCallbackDataFits(t *testing.T) {
+	name := strings.Repeat("я", 20)
+	for _, action := range []string{switchAccountAction, removeAccountAction} {
+		if data := fmt.Sprintf("%s:%s:%s", AccountCallback, action, name); len(data) > 64 {
+			t.Errorf("callback data %s is %d bytes long", data, len(data))
+		}
+	}
+}
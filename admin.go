@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	tbot "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxMessageLength is the longest text Telegram accepts in a single message
+	maxMessageLength = 4096
+	// broadcastInterval keeps broadcasting well below Telegram rate limits
+	broadcastInterval = 50 * time.Millisecond
+)
+
+var adminCommands = map[string]bool{
+	StatsCmd:     true,
+	UsersCmd:     true,
+	BanCmd:       true,
+	UnbanCmd:     true,
+	BroadcastCmd: true,
+}
+
+func isAdminCommand(update *tbot.Update) bool {
+	return update.Message != nil && update.Message.IsCommand() && adminCommands[update.Message.Command()]
+}
+
+// authorize checks the sender of the update is allowed to use telice and, for admin commands, is an admin.
+// Updates without a sender are not related to any user and are always authorized.
+func (b *bot) authorize(update *tbot.Update) error {
+	user := update.SentFrom()
+	if user == nil {
+		return nil
+	}
+
+	if b.bans.IsBanned(user.ID) {
+		return NewBotError("Sorry, you are not allowed to use this bot.")
+	}
+
+	if !b.cfg.isAllowed(user.ID) {
+		return NewBotError(fmt.Sprintf("Sorry, this bot is private. Please, ask its owner to allow your Telegram user id %d.", user.ID))
+	}
+
+	if isAdminCommand(update) && !b.cfg.adminIds[user.ID] {
+		return NewBotError("Sorry, this command is only available to admins.")
+	}
+
+	return nil
+}
+
+// reject answers the update that has not been authorized. Nothing is sent to group chats to not disturb their members.
+func (b *bot) reject(update *tbot.Update, err error) {
+	chat := update.FromChat()
+	log.WithError(err).Infof("Rejected update %d from user %d", update.UpdateID, update.SentFrom().ID)
+
+	if chat == nil || !chat.IsPrivate() {
+		return
+	}
+
+	b.dispatcher.Enqueue(chat.ID, func() {
+		b.handleError(chat.ID, err)
+	})
+}
+
+// handleAdminCommand handles commands available to users listed in AdminIdsEnv. They do not need a session.
+func (b *bot) handleAdminCommand(key sessionKey, msg *tbot.Message) error {
+	if key.isGroup() {
+		return NewBotError("Admin commands are only available in a private chat with me.")
+	}
+
+	args := strings.TrimSpace(msg.CommandArguments())
+	switch msg.Command() {
+	case StatsCmd:
+		return b.handleStatsCommand(key.chatId)
+	case UsersCmd:
+		return b.handleUsersCommand(key.chatId)
+	case BanCmd:
+		return b.handleBanCommand(key.chatId, args)
+	case UnbanCmd:
+		return b.handleUnbanCommand(key.chatId, args)
+	case BroadcastCmd:
+		return b.handleBroadcastCommand(key.chatId, args)
+	}
+
+	return nil
+}
+
+// sessions returns all stored sessions ordered by chat and user.
+func (b *bot) sessions() []*session {
+	keys := b.sessionProvider.Keys()
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].chatId != keys[j].chatId {
+			return keys[i].chatId < keys[j].chatId
+		}
+		return keys[i].userId < keys[j].userId
+	})
+
+	sessions := make([]*session, 0, len(keys))
+	for _, k := range keys {
+		if s, ok := b.sessionProvider.TryGet(k); ok {
+			sessions = append(sessions, s)
+		}
+	}
+
+	return sessions
+}
+
+func (b *bot) handleStatsCommand(chatId int64) error {
+	var private, group, guests, accounts, jobs, queued int
+	users := make(map[int64]bool)
+	for _, s := range b.sessions() {
+		users[s.userId] = true
+		if s.key().isGroup() {
+			group++
+		} else {
+			private++
+		}
+
+		if s.grantedBy != 0 {
+			guests++
+		} else {
+			accounts += 1 + len(s.accounts)
+		}
+
+		jobs += len(s.jobs)
+		for _, q := range s.queues {
+			queued += len(q)
+		}
+	}
+
+	allowed := "everyone"
+	if b.cfg.allowedUsers != nil {
+		allowed = fmt.Sprintf("%d users and admins", len(b.cfg.allowedUsers))
+	}
+
+	buf := bytes.Buffer{}
+	buf.WriteString(fmt.Sprintf("Users: %d\n", len(users)))
+	buf.WriteString(fmt.Sprintf("Sessions: %d private, %d in group chats\n", private, group))
+	buf.WriteString(fmt.Sprintf("Guests: %d\n", guests))
+	buf.WriteString(fmt.Sprintf("Linked Yandex accounts: %d\n", accounts))
+	buf.WriteString(fmt.Sprintf("Scheduled jobs: %d\n", jobs))
+	buf.WriteString(fmt.Sprintf("Queued items: %d\n", queued))
+	buf.WriteString(fmt.Sprintf("Banned users: %d\n", b.bans.Count()))
+	buf.WriteString(fmt.Sprintf("Allowed: %s", allowed))
+	b.send(chatId, buf.String())
+
+	return nil
+}
+
+func (b *bot) handleUsersCommand(chatId int64) error {
+	sessions := b.sessions()
+	if len(sessions) == 0 {
+		return NewBotError("There are no users yet.")
+	}
+
+	lines := make([]string, 0, len(sessions)+1)
+	lines = append(lines, fmt.Sprintf("Sessions (%d):", len(sessions)))
+	for _, s := range sessions {
+		line := fmt.Sprintf("• %d", s.userId)
+		if s.key().isGroup() {
+			line += fmt.Sprintf(" in group %d", s.chatId)
+		}
+
+		if s.grantedBy != 0 {
+			line += fmt.Sprintf(", guest of %d", s.grantedBy)
+		} else {
+			line += fmt.Sprintf(", accounts: %d", 1+len(s.accounts))
+		}
+		if len(s.grants) > 0 {
+			line += fmt.Sprintf(", guests: %d", len(s.grants))
+		}
+		if s.sharedPermissions != 0 {
+			line += ", shared with the group"
+		}
+		lines = append(lines, line)
+	}
+
+	// Long lists are split, so every message fits the Telegram limit
+	buf := bytes.Buffer{}
+	for _, l := range lines {
+		if buf.Len()+len(l)+1 > maxMessageLength {
+			b.send(chatId, buf.String())
+			buf.Reset()
+		}
+		buf.WriteString(l + "\n")
+	}
+	b.send(chatId, buf.String())
+
+	return nil
+}
+
+// handleBanCommand bans the user and removes all of their sessions. Every session is removed by the worker of its chat.
+func (b *bot) handleBanCommand(chatId int64, args string) error {
+	userId, err := parseUserIdArg(BanCmd, args)
+	if err != nil {
+		return err
+	}
+	if b.cfg.adminIds[userId] {
+		return NewBotError("Admins cannot be banned.")
+	}
+
+	b.bans.Ban(userId)
+
+	removed := 0
+	for _, k := range b.sessionProvider.Keys() {
+		if k.userId != userId {
+			continue
+		}
+
+		key := k
+		b.dispatcher.EnqueueAsync(key.chatId, func() {
+			s, ok := b.sessionProvider.TryGet(key)
+			if !ok {
+				return
+			}
+
+			if s.grantedBy != 0 {
+				b.leaveGrant(s)
+				return
+			}
+			//goland:noinspection GoUnhandledErrorResult
+			b.removeSession(s)
+		})
+		removed++
+	}
+
+	b.send(chatId, fmt.Sprintf("User %d has been banned. Sessions removed: %d.", userId, removed))
+
+	return nil
+}
+
+func (b *bot) handleUnbanCommand(chatId int64, args string) error {
+	userId, err := parseUserIdArg(UnbanCmd, args)
+	if err != nil {
+		return err
+	}
+	if !b.bans.IsBanned(userId) {
+		return NewBotError(fmt.Sprintf("User %d is not banned.", userId))
+	}
+
+	b.bans.Unban(userId)
+	b.send(chatId, fmt.Sprintf("User %d has been unbanned.", userId))
+
+	return nil
+}
+
+// handleBroadcastCommand sends the text to every chat with a session. Messages are sent in the background,
+// the admin is notified once all of them are sent.
+func (b *bot) handleBroadcastCommand(chatId int64, text string) error {
+	if text == "" {
+		return NewBotError(fmt.Sprintf("Please, specify the message, e.g. /%s The bot will be down for maintenance tonight", BroadcastCmd))
+	}
+
+	chats := make([]int64, 0)
+	seen := make(map[int64]bool)
+	for _, k := range b.sessionProvider.Keys() {
+		if !seen[k.chatId] {
+			seen[k.chatId] = true
+			chats = append(chats, k.chatId)
+		}
+	}
+
+	b.send(chatId, fmt.Sprintf("Sending the message to %d chats…", len(chats)))
+
+	go func() {
+		sent := 0
+		for _, id := range chats {
+			if _, err := b.api.Send(tbot.NewMessage(id, text)); err != nil {
+				log.WithError(err).Errorf("Could not broadcast the message to chat %d", id)
+			} else {
+				sent++
+			}
+			time.Sleep(broadcastInterval)
+		}
+
+		b.send(chatId, fmt.Sprintf("The message has been sent to %d of %d chats.", sent, len(chats)))
+	}()
+
+	return nil
+}
+
+func parseUserIdArg(cmd string, args string) (int64, error) {
+	userId, err := strconv.ParseInt(args, 10, 64)
+	if err != nil || userId <= 0 {
+		return 0, NewBotError(fmt.Sprintf("Please, specify the Telegram user id, e.g. /%s 123456789", cmd))
+	}
+
+	return userId, nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import "sync"
+
+// BanList holds Telegram users banned by admins, see /ban.
+// Implementations must be safe for concurrent use.
+type BanList interface {
+	Ban(userId int64)
+	Unban(userId int64)
+	IsBanned(userId int64) bool
+	Count() int
+}
+
+type inMemoryBanList struct {
+	mu    sync.RWMutex
+	users map[int64]bool
+}
+
+//goland:noinspection GoExportedFuncWithUnexportedType
+func NewInMemoryBanList() *inMemoryBanList {
+	return &inMemoryBanList{users: make(map[int64]bool)}
+}
+
+func (l *inMemoryBanList) Ban(userId int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.users[userId] = true
+}
+
+func (l *inMemoryBanList) Unban(userId int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.users, userId)
+}
+
+func (l *inMemoryBanList) IsBanned(userId int64) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.users[userId]
+}
+
+func (l *inMemoryBanList) Count() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.users)
+}
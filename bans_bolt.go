@@ -0,0 +1,74 @@
+package main
+
+import (
+	bolt "go.etcd.io/bbolt"
+	"time"
+)
+
+var bansBucket = []byte("bans")
+
+// boltBanList keeps banned users in the sessions database, so bans survive restarts along with sessions.
+type boltBanList struct {
+	db *bolt.DB
+}
+
+func NewBoltBanList(p *boltSessionProvider) (*boltBanList, error) {
+	err := p.db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bansBucket)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &boltBanList{p.db}, nil
+}
+
+func (l *boltBanList) Ban(userId int64) {
+	err := l.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bansBucket).Put(banDbKey(userId), []byte(time.Now().UTC().Format(time.RFC3339)))
+	})
+	if err != nil {
+		log.WithError(err).Errorf("Could not ban user %d", userId)
+	}
+}
+
+func (l *boltBanList) Unban(userId int64) {
+	err := l.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bansBucket).Delete(banDbKey(userId))
+	})
+	if err != nil {
+		log.WithError(err).Errorf("Could not unban user %d", userId)
+	}
+}
+
+func (l *boltBanList) IsBanned(userId int64) bool {
+	var banned bool
+	err := l.db.View(func(tx *bolt.Tx) error {
+		banned = tx.Bucket(bansBucket).Get(banDbKey(userId)) != nil
+		return nil
+	})
+	if err != nil {
+		log.WithError(err).Errorf("Could not check ban of user %d", userId)
+	}
+
+	return banned
+}
+
+func (l *boltBanList) Count() int {
+	var n int
+	err := l.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(bansBucket).Stats().KeyN
+		return nil
+	})
+	if err != nil {
+		log.WithError(err).Error("Could not count bans")
+	}
+
+	return n
+}
+
+// banDbKey returns the user id as the key. The value holds the time of the ban.
+func banDbKey(userId int64) []byte {
+	return int64DbKey(userId)
+}
@@ -12,52 +12,163 @@ import (
 )
 
 type bot struct {
-	token           string
+	cfg             *botConfig
 	api             *tbot.BotAPI
 	yaClient        *YandexClient
 	sessionProvider SessionProvider
 	cacheProvider   CacheProvider
+	stateSigner     *oauthStateSigner
+	dispatcher      *dispatcher
+	mediaRegistry   *mediaRegistry
+	youTubeClient   *YouTubeClient
+	queueTimers     *queueTimers
+	jobTimers       *jobTimers
+	bans            BanList
 }
 
-func NewBot(token string, yandexClientId string) *bot {
-	api, err := tbot.NewBotAPI(token)
+func NewBot(cfg *botConfig, sp SessionProvider, bl BanList) *bot {
+	api, err := tbot.NewBotAPI(cfg.telegramToken)
 	if err != nil {
 		log.WithError(err).Fatal("Could not create a new bot API instance")
 	}
 
 	log.Infof("Bot has started. Authorized on account %s", api.Self.UserName)
 
-	sp := NewInMemorySessionProvider()
 	cp := NewInMemoryCacheProvider()
-	yc := NewYandexClient(yandexClientId, cp, &http.Client{})
+	yc := NewYandexClient(cfg.yandexClientId, cfg.yandexClientSecret, cfg.oauthRedirectUri(), cp, &http.Client{})
+	ss := newOAuthStateSigner(cfg.telegramToken)
+
+	mr := NewMediaRegistry(
+		NewYouTubeProvider(),
+		NewVKVideoProvider(),
+		NewRutubeProvider(),
+		NewYandexMusicProvider(),
+	)
+
+	var ytc *YouTubeClient
+	if cfg.youTubeApiKey != "" {
+		ytc = NewYouTubeClient(cfg.youTubeApiKey, &http.Client{})
+	}
+
+	b := &bot{
+		cfg:             cfg,
+		api:             api,
+		yaClient:        yc,
+		sessionProvider: sp,
+		cacheProvider:   cp,
+		stateSigner:     ss,
+		mediaRegistry:   mr,
+		youTubeClient:   ytc,
+		queueTimers:     newQueueTimers(),
+		jobTimers:       newJobTimers(),
+		bans:            bl,
+	}
+	b.dispatcher = newDispatcher(cfg.workers, b.handleUpdate)
 
-	return &bot{token, api, yc, sp, cp}
+	return b
 }
 
 func (b *bot) Run() {
+	if b.yaClient.isCodeFlowEnabled() {
+		http.HandleFunc(OAuthCallbackPath, b.handleOAuthCallback)
+		log.Infof("Using OAuth authorization code flow with callback %s", b.cfg.oauthRedirectUri())
+	}
+
+	go b.watchTokensExpiry()
+	b.restoreScheduledJobs()
+
+	if b.cfg.allowedUsers != nil {
+		log.Infof("Only %d allowed users and %d admins may use the bot", len(b.cfg.allowedUsers), len(b.cfg.adminIds))
+	}
+
+	for update := range b.getUpdatesChan() {
+		// Updates are authorized before dispatching, so no handler is ever run for users who are not allowed
+		if err := b.authorize(&update); err != nil {
+			b.reject(&update, err)
+			continue
+		}
+
+		b.dispatcher.Dispatch(update)
+	}
+
+	b.dispatcher.Stop()
+	log.Infof("FINISHED")
+}
+
+func (b *bot) getUpdatesChan() tbot.UpdatesChannel {
+	if b.cfg.publicUrl != "" {
+		updates, err := b.listenForWebhook()
+		if err == nil {
+			return updates
+		}
+
+		log.WithError(err).Error("Could not set up webhook. Falling back to long polling")
+	}
+
+	// Long polling doesn't work while a webhook is set
+	_, err := b.api.Request(tbot.DeleteWebhookConfig{})
+	if err != nil {
+		log.WithError(err).Error("Could not delete webhook")
+	}
+
 	u := tbot.NewUpdate(0)
 	u.Timeout = 60
 
-	updates := b.api.GetUpdatesChan(u)
-	for update := range updates {
-		s, found := b.sessionProvider.TryGet(update.FromChat().ID)
-		if !found && b.isAuthorizationRequired(&update) {
-			b.handleError(update.FromChat().ID, NewBotError(fmt.Sprintf("Authentication required. Please, click /%s to initiate.", StartCmd)))
-			continue
+	log.Info("Receiving updates using long polling")
+
+	return b.api.GetUpdatesChan(u)
+}
+
+func (b *bot) handleUpdate(update tbot.Update) {
+	if update.FromChat() == nil || update.SentFrom() == nil {
+		return
+	}
+
+	key := updateSessionKey(update.FromChat(), update.SentFrom())
+	if key.isGroup() && isGroupChatter(&update) {
+		return
+	}
+
+	if isAdminCommand(&update) {
+		b.handleError(key.chatId, b.handleAdminCommand(key, update.Message))
+		return
+	}
+
+	s, found, err := b.loadSession(key)
+	if err != nil {
+		b.handleError(key.chatId, err)
+		return
+	}
+
+	if found && s.grantedBy != 0 && b.isAuthorizationRequired(&update) && !isGuestAllowed(&update) {
+		b.handleError(key.chatId, NewBotError("Sorry, you can only play media and control playback on the stations shared with you."))
+		return
+	}
+
+	if !found && b.isAuthorizationRequired(&update) {
+		if !key.isGroup() {
+			b.handleError(key.chatId, NewBotError(fmt.Sprintf("Authentication required. Please, click /%s to initiate.", StartCmd)))
+			return
 		}
 
-		if update.Message != nil {
-			handled, err := b.tryHandleCommandMessage(s, update)
-			if !handled {
-				err = b.handleMessage(s, update.Message)
-			}
+		// Members without a Yandex login of their own use the stations shared with the chat
+		s, err = b.sharedSession(key.chatId, requiredPermission(&update))
+		if err != nil {
+			b.handleError(key.chatId, err)
+			return
+		}
+	}
 
-			b.handleError(update.FromChat().ID, err)
-		} else if update.CallbackQuery != nil {
-			b.handleCallbackQuery(s, update.CallbackQuery)
+	if update.Message != nil {
+		handled, err := b.tryHandleCommandMessage(s, update)
+		if !handled {
+			err = b.handleMessage(s, update.Message)
 		}
+
+		b.handleError(update.FromChat().ID, err)
+	} else if update.CallbackQuery != nil {
+		b.handleCallbackQuery(s, update.CallbackQuery)
 	}
-	log.Infof("FINISHED")
 }
 
 func (b *bot) handleCallbackQuery(s *session, callback *tbot.CallbackQuery) {
@@ -65,14 +176,51 @@ func (b *bot) handleCallbackQuery(s *session, callback *tbot.CallbackQuery) {
 		return
 	}
 
-	parts := strings.Split(callback.Data, ":")
+	parts := strings.SplitN(callback.Data, ":", 2)
+	if len(parts) != 2 {
+		return
+	}
 	method, data := parts[0], parts[1]
 
 	switch method {
 	case SelectAsDefaultCallback:
 		b.handleSelectAsDefaultCommandCallback(s, data)
+	case SelectGroupAsDefaultCallback:
+		b.handleSelectGroupAsDefaultCallback(s, data)
 	case OneTimePlayMediaCallback:
 		b.handleOneTimePlayMediaCallback(s, callback.Message.ReplyToMessage, data)
+	case PlaylistModeCallback:
+		b.handlePlaylistModeCallback(s, callback.Message.ReplyToMessage, data)
+	case AddToQueueCallback:
+		b.handleAddToQueueCallback(s, callback.Message.ReplyToMessage, data)
+	case NextCallback:
+		b.handleNextCommandCallback(s, data)
+	case ClearQueueCallback:
+		b.handleClearQueueCommandCallback(s, data)
+	case RemoteControlCallback:
+		b.handleRemoteControlCallback(s, data)
+	case BroadcastCallback:
+		b.handleBroadcastCallback(s, callback.Message.ReplyToMessage, data)
+	case ScheduleCallback:
+		b.handleScheduleCallback(s, callback.Message.ReplyToMessage, data)
+	case CancelScheduledCallback:
+		b.handleCancelScheduledCallback(s, data)
+	case SayCallback:
+		b.handleSayCallback(s, callback.Message.ReplyToMessage, data)
+	case ScenarioCallback:
+		b.handleScenarioCallback(s, data)
+	case DeviceCallback:
+		b.handleDeviceCallback(s, data)
+	case DeviceActionCallback:
+		b.handleDeviceActionCallback(s, data)
+	case HouseholdCallback:
+		b.handleHouseholdCallback(s, data)
+	case AccountCallback:
+		b.handleAccountCallback(s, data)
+	case GroupCallback:
+		b.handleGroupCallback(s, data)
+	case ShareCallback:
+		b.handleShareCallback(s, data)
 	}
 }
 
@@ -82,54 +230,81 @@ func (b *bot) handleMessage(s *session, msg *tbot.Message) error {
 	if match == nil {
 		return NewBotError("URL link is not found in the message. Please, send me a valid one.")
 	}
-	url := string(match)
 
-	// region YouTube
+	m, err := b.mediaRegistry.Resolve(string(match))
+	if err != nil {
+		return err
+	}
 
-	loweredUrl := strings.ToLower(url)
-	if !strings.Contains(loweredUrl, "youtube") && !strings.Contains(loweredUrl, "youtu.be") {
-		return NewBotError("Sorry, but I support only YouTube at the moment :(")
+	if _, ok := youTubePlaylistOf(m); ok {
+		return b.askPlaylistMode(s, msg)
 	}
-	url = reformatYouTubeUrl(url)
 
-	// endregion
+	return b.shareMedia(s, msg, m, "")
+}
 
-	devices, err := b.yaClient.getYandexStations(s)
+// shareMedia plays the media on the default device or asks the user to select one.
+// mode is only used for playlists, see playYouTubePlaylist.
+func (b *bot) shareMedia(s *session, msg *tbot.Message, m *media, mode string) error {
+	devices, err := b.getYandexStations(s)
 	if err != nil {
 		return err
 	}
-	if len(devices) == 0 {
-		return NewBotError("I didn't find any yandex stations. Are they configured properly?")
-	}
 
 	err = b.yaClient.refreshTokens(s)
 	if err != nil {
 		return err
 	}
 
+	if s.defaultGroupId != "" {
+		return b.broadcast(s, m, BroadcastGroup, s.defaultGroupId)
+	}
+
+	// Stations of other linked accounts are offered along with the ones of the active account
+	spanning := s.spanAccounts && len(s.accounts) > 0
+
+	var target *device
 	if s.defaultDevice != nil {
 		for _, d := range devices {
 			if d.Id == s.defaultDevice.Id {
-				return b.yaClient.playMedia(s, nil, url)
+				target = s.defaultDevice
 			}
 		}
 
-		return NewBotError("Selected device is not currently available. Please, try again later.")
+		if target == nil {
+			return NewBotError("Selected device is not currently available. Please, try again later.")
+		}
+	} else if len(devices) == 1 && !spanning {
+		target = &devices[0]
 	}
 
-	if len(devices) == 1 {
-		return b.yaClient.playMedia(s, &devices[0], url)
+	if target != nil {
+		// Don't interrupt queued playback without asking
+		if mode == "" && (len(s.queue(target.Id)) > 0 || b.queueTimers.Active(s.chatId, target.Id)) {
+			return b.askPlayNowOrQueue(s, msg, target)
+		}
+
+		return b.play(s, target, m, mode)
 	}
 
 	iotInfo, _ := b.yaClient.getYandexSmartHomeInfo(s)
 
-	strs := b.yandexStationsToString(s, devices, iotInfo.Rooms, iotInfo.Households)
+	accounts := []accountStations{{s, devices, iotInfo}}
+	if spanning {
+		accounts = append(accounts, b.spannedAccountStations(s)...)
+	}
 
 	rows := make([][]tbot.InlineKeyboardButton, 0)
-	for i, s := range strs {
-		// Notice, Telegram api requires button data to be 64 bytes or less
-		data := fmt.Sprintf("%s:%s", OneTimePlayMediaCallback, devices[i].Id)
-		rows = append(rows, tbot.NewInlineKeyboardRow(tbot.NewInlineKeyboardButtonData(s, data)))
+	for _, a := range accounts {
+		label := ""
+		if spanning {
+			label = a.session.activeAccountName()
+		}
+		rows = append(rows, b.stationRows(a.session, a.devices, a.info, mode, label)...)
+	}
+
+	if mode == "" {
+		rows = append(rows, broadcastRows(accounts, spanning)...)
 	}
 	keyboard := tbot.NewInlineKeyboardMarkup(rows...)
 
@@ -143,6 +318,70 @@ func (b *bot) handleMessage(s *session, msg *tbot.Message) error {
 	return nil
 }
 
+// stationRows returns device picker rows. Station names are prefixed with the account label if it is not empty.
+func (b *bot) stationRows(s *session, devices []device, info *iotInfo, mode string, label string) [][]tbot.InlineKeyboardButton {
+	strs := b.yandexStationsToString(s, devices, info.Rooms, info.Households)
+
+	rows := make([][]tbot.InlineKeyboardButton, 0)
+	for i, str := range strs {
+		if label != "" {
+			str = fmt.Sprintf("[%s] %s", label, str)
+		}
+
+		// Notice, Telegram api requires button data to be 64 bytes or less
+		data := fmt.Sprintf("%s:%s", OneTimePlayMediaCallback, devices[i].Id)
+		if mode != "" {
+			data = fmt.Sprintf("%s:%s", data, mode)
+			rows = append(rows, tbot.NewInlineKeyboardRow(tbot.NewInlineKeyboardButtonData(str, data)))
+			continue
+		}
+
+		queueData := fmt.Sprintf("%s:%s", AddToQueueCallback, devices[i].Id)
+		rows = append(rows, tbot.NewInlineKeyboardRow(
+			tbot.NewInlineKeyboardButtonData(str, data),
+			tbot.NewInlineKeyboardButtonData("Add to queue", queueData),
+		))
+	}
+
+	return rows
+}
+
+func (b *bot) askPlayNowOrQueue(s *session, msg *tbot.Message, d *device) error {
+	keyboard := tbot.NewInlineKeyboardMarkup(tbot.NewInlineKeyboardRow(
+		tbot.NewInlineKeyboardButtonData("Play now", fmt.Sprintf("%s:%s", OneTimePlayMediaCallback, d.Id)),
+		tbot.NewInlineKeyboardButtonData("Add to queue", fmt.Sprintf("%s:%s", AddToQueueCallback, d.Id)),
+	))
+
+	replyMsg := tbot.NewMessage(s.chatId, fmt.Sprintf("`%s` is playing queued media. Would you like to interrupt it?", d.Name))
+	replyMsg.ReplyToMessageID = msg.MessageID
+	replyMsg.ReplyMarkup = keyboard
+
+	//goland:noinspection GoUnhandledErrorResult
+	b.api.Send(replyMsg)
+
+	return nil
+}
+
+// askStation replies to the message with the station keyboard. Callback data is `<callback>:<device id>`.
+func (b *bot) askStation(s *session, msg *tbot.Message, devices []device, callback string, prompt string) {
+	iotInfo, _ := b.yaClient.getYandexSmartHomeInfo(s)
+
+	strs := b.yandexStationsToString(s, devices, iotInfo.Rooms, iotInfo.Households)
+
+	rows := make([][]tbot.InlineKeyboardButton, 0)
+	for i, str := range strs {
+		data := fmt.Sprintf("%s:%s", callback, devices[i].Id)
+		rows = append(rows, tbot.NewInlineKeyboardRow(tbot.NewInlineKeyboardButtonData(str, data)))
+	}
+
+	replyMsg := tbot.NewMessage(s.chatId, prompt)
+	replyMsg.ReplyToMessageID = msg.MessageID
+	replyMsg.ReplyMarkup = tbot.NewInlineKeyboardMarkup(rows...)
+
+	//goland:noinspection GoUnhandledErrorResult
+	b.api.Send(replyMsg)
+}
+
 func (b *bot) tryHandleCommandMessage(s *session, update tbot.Update) (bool, error) {
 	if !update.Message.IsCommand() {
 		return false, nil
@@ -157,26 +396,62 @@ func (b *bot) handleCommand(s *session, msg *tbot.Message) error {
 
 	switch cmd {
 	case StartCmd:
-		return b.handleStartCommand(msg.Chat.ID, args)
+		if strings.HasPrefix(args, invitePrefix) {
+			return b.handleInviteLink(updateSessionKey(msg.Chat, msg.From), msg.From, args)
+		}
+		return b.handleStartCommand(updateSessionKey(msg.Chat, msg.From), args)
 	case ListDevicesCmd:
 		return b.handleListDevicesCommand(s)
 	case SelectAsDefaultCmd:
 		return b.handleSelectAsDefaultCommand(s)
 	case ResetCmd:
-		return b.handleResetCommand(s)
+		return b.handleLogoutCommand(s, "Session has been reset successfully.")
+	case LogoutCmd:
+		return b.handleLogoutCommand(s, "You have been logged out successfully.")
+	case QueueCmd:
+		return b.handleQueueCommand(s)
+	case NextCmd:
+		return b.handleNextCommand(s)
+	case ClearQueueCmd:
+		return b.handleClearQueueCommand(s)
+	case PauseCmd, ResumeCmd, StopCmd, VolumeCmd, SeekCmd:
+		return b.handleRemoteCommand(s, cmd, strings.TrimSpace(args))
+	case AtCmd, InCmd:
+		return b.handleScheduleCommand(s, msg)
+	case ScheduledCmd:
+		return b.handleScheduledCommand(s)
+	case TimeZoneCmd:
+		return b.handleTimeZoneCommand(s, args)
+	case SayCmd:
+		return b.handleSayCommand(s, msg)
+	case ScenariosCmd:
+		return b.handleScenariosCommand(s)
+	case DevicesCmd:
+		return b.handleDevicesCommand(s)
+	case HouseholdCmd:
+		return b.handleHouseholdCommand(s)
+	case AccountsCmd:
+		return b.handleAccountsCommand(s, args)
+	case GroupCmd:
+		return b.handleGroupCommand(s)
+	case ShareCmd:
+		return b.handleShareCommand(s)
 	}
 
 	return nil
 }
 
-func (b *bot) handleStartCommand(chatId int64, args string) error {
-	if s, ok := b.sessionProvider.TryGet(chatId); ok {
+func (b *bot) handleStartCommand(key sessionKey, args string) error {
+	_, authenticated := b.sessionProvider.TryGet(key)
+	if authenticated && args == "" {
 		text := "Looks like everything is ready. Feel free to send me a link to share with your Alice."
-		b.send(s.chatId, text)
+		b.send(key.chatId, text)
 		return nil
 	}
 
-	if args != "" {
+	// Tokens passed with the deep link are not bound to the chat,
+	// so they are only accepted when authorization code flow is not configured
+	if args != "" && !key.isGroup() && !b.yaClient.isCodeFlowEnabled() {
 		decoded, err := base64.StdEncoding.DecodeString(args)
 		if err != nil {
 			log.WithError(err).Errorf("Error occurred decoding base64 string `%v`", decoded)
@@ -188,10 +463,7 @@ func (b *bot) handleStartCommand(chatId int64, args string) error {
 			return NewBotError("Could not complete authentication process. Please, try again.")
 		}
 
-		s := NewSession(chatId, oauthToken, csrfToken)
-		b.sessionProvider.SaveOrUpdate(s)
-
-		b.send(chatId, "Authentication is complete.\nSend me a link and I will share it with Alice. Have fun!")
+		b.completeAuthentication(key, oauthToken, csrfToken, "")
 
 		return nil
 	}
@@ -202,10 +474,8 @@ Hello!
 To use telice first we need to authenticate you. Please, click on the link down below to authenticate. 
 Authentication is done using Yandex.OAuth. I will never ask you for login or password.
 	`
-	b.send(chatId, text)
-	b.send(chatId, b.yaClient.getOAuthUrl())
 
-	return nil
+	return b.sendLoginUrl(key, text)
 }
 
 func (b *bot) handleListDevicesCommand(s *session) error {
@@ -217,11 +487,13 @@ func (b *bot) handleListDevicesCommand(s *session) error {
 	iotInfo, _ := b.yaClient.getYandexSmartHomeInfo(s)
 
 	msgText := b.formatYandexStationsMessage(s, devices, iotInfo.Rooms, iotInfo.Households)
+	msgText += formatStationGroups(s, stationGroups(iotInfo, devices), devices)
 	b.send(s.chatId, msgText)
 
 	return nil
 }
 
+// getYandexStations returns the stations of the active household the session may use. See /household and /share.
 func (b *bot) getYandexStations(s *session) ([]device, error) {
 	devices, err := b.yaClient.getYandexStations(s)
 	if err != nil {
@@ -232,6 +504,16 @@ func (b *bot) getYandexStations(s *session) ([]device, error) {
 		return nil, NewBotError("I didn't find any yandex stations. Are they configured properly?")
 	}
 
+	devices = filterGrantedDevices(s, devices)
+	if len(devices) == 0 {
+		return nil, NewBotError("None of the stations shared with you is currently available. Please, try again later.")
+	}
+
+	devices = b.filterActiveHousehold(s, devices)
+	if len(devices) == 0 {
+		return nil, NewBotError(fmt.Sprintf("I didn't find any yandex stations in the active household. Use /%s to switch it.", HouseholdCmd))
+	}
+
 	return devices, nil
 }
 
@@ -295,6 +577,10 @@ func (b *bot) handleSelectAsDefaultCommand(s *session) error {
 		data := fmt.Sprintf("%s:%s", SelectAsDefaultCallback, devices[i].Id)
 		rows = append(rows, tbot.NewInlineKeyboardRow(tbot.NewInlineKeyboardButtonData(s, data)))
 	}
+	for _, g := range stationGroups(iotInfo, devices) {
+		data := fmt.Sprintf("%s:%s", SelectGroupAsDefaultCallback, g.Id)
+		rows = append(rows, tbot.NewInlineKeyboardRow(tbot.NewInlineKeyboardButtonData(fmt.Sprintf("👥 %s", g.Name), data)))
+	}
 	keyboard := tbot.NewInlineKeyboardMarkup(rows...)
 
 	msg := tbot.NewMessage(s.chatId, "Please, select the station you want to make the default one.")
@@ -306,21 +592,55 @@ func (b *bot) handleSelectAsDefaultCommand(s *session) error {
 	return nil
 }
 
-func (b *bot) handleResetCommand(s *session) error {
-	b.sessionProvider.Delete(s.chatId)
+// handleLogoutCommand revokes OAuth tokens of all linked accounts at Yandex and removes everything telice knows about the chat.
+// The session is removed even if revocation fails. Guests only stop using the shared stations.
+func (b *bot) handleLogoutCommand(s *session, text string) error {
+	if s.grantedBy != 0 {
+		b.leaveGrant(s)
+		b.send(s.chatId, fmt.Sprintf("You don't use the stations shared with you anymore. Click /%s to log in with your own Yandex account.", StartCmd))
+		return nil
+	}
 
-	ck1 := fmt.Sprintf("%d_%s", s.chatId, "iotuserinfo")
-	b.cacheProvider.Delete(ck1)
+	revokeErr := b.removeSession(s)
 
-	b.send(s.chatId, "Session has been reset successfully.")
+	if revokeErr != nil {
+		text += "\nHowever, I could not revoke access to your Yandex account. " +
+			"You can revoke it manually at https://id.yandex.com/security/apps"
+	} else {
+		text += "\nAccess to your Yandex account has been revoked."
+	}
+	b.send(s.chatId, text)
 
 	return nil
 }
 
+// removeSession revokes OAuth tokens of all linked accounts and removes the session. The error of the last failed revocation is returned.
+func (b *bot) removeSession(s *session) error {
+	revokeErr := b.yaClient.revokeOAuthToken(s.oauthToken.value)
+	if revokeErr != nil {
+		log.WithError(revokeErr).Errorf("Could not revoke OAuth token for chat %d", s.chatId)
+	}
+	for _, a := range s.accounts {
+		if err := b.yaClient.revokeOAuthToken(a.oauthToken.value); err != nil {
+			log.WithError(err).Errorf("Could not revoke OAuth token of account %s for chat %d", a.name, s.chatId)
+			revokeErr = err
+		}
+	}
+
+	for _, j := range s.jobs {
+		b.jobTimers.Stop(j.id)
+	}
+	b.sessionProvider.Delete(s.key())
+	b.cacheProvider.DeleteByPrefix(chatCacheKeyPrefix(s.chatId))
+
+	return revokeErr
+}
+
 func (b *bot) handleSelectAsDefaultCommandCallback(s *session, deviceId string) {
-	devices, err := b.yaClient.getYandexStations(s)
+	// Only stations of the active household may be selected
+	devices, err := b.getYandexStations(s)
 	if err != nil {
-		log.WithError(err).Error("Could not process callback. Error occurred trying to get yandex stations")
+		b.handleError(s.chatId, err)
 		return
 	}
 
@@ -337,25 +657,59 @@ func (b *bot) handleSelectAsDefaultCommandCallback(s *session, deviceId string)
 	b.send(s.chatId, "Selected device is not currently available. Please, try again later.")
 }
 
-func (b *bot) handleOneTimePlayMediaCallback(s *session, replyToMessage *tbot.Message, deviceId string) {
-	r, _ := regexp.Compile(URLRegexPattern)
-	url := string(r.Find([]byte(replyToMessage.Text)))
+func (b *bot) handleOneTimePlayMediaCallback(s *session, replyToMessage *tbot.Message, data string) {
+	deviceId, mode, _ := strings.Cut(data, ":")
+
 	// It is guaranteed that at this point
-	// we might have only youtube link
-	url = reformatYouTubeUrl(url)
+	// we might have only supported media link
+	m, err := b.resolveMessageMedia(replyToMessage)
+	if err != nil {
+		log.WithError(err).Error("Could not process callback. Error occurred trying to resolve media")
+		return
+	}
 
-	log.Infof(url)
+	log.Infof("Playing %s media %s", m.provider.Name(), m.url)
 
-	devices, err := b.yaClient.getYandexStations(s)
+	// Sessions restored from persistent storage come without CSRF token,
+	// so the tokens are refreshed while the device is prepared
+	s, d, err := b.prepareDevice(s, deviceId)
 	if err != nil {
+		b.handleError(s.chatId, err)
 		return
 	}
 
-	for _, d := range devices {
-		if d.Id == deviceId {
-			b.yaClient.playMedia(s, &d, url)
-		}
+	b.handleError(s.chatId, b.play(s, d, m, mode))
+}
+
+// play starts the media on the device or on the default one if d is nil.
+func (b *bot) play(s *session, d *device, m *media, mode string) error {
+	if d == nil {
+		d = s.defaultDevice
+	}
+	if d == nil {
+		return NewBotError("Cannot play media. No device has been selected.")
+	}
+
+	if yu, ok := youTubePlaylistOf(m); ok && mode != "" {
+		return b.playYouTubePlaylist(s, d, yu, mode)
+	}
+
+	// Media shared explicitly pauses the queue of the device. Use /next to resume it
+	b.queueTimers.Stop(s.chatId, d.Id)
+
+	err := b.yaClient.playMedia(s, d, m)
+	if err != nil {
+		return err
 	}
+
+	b.sendNowPlaying(s, d, m.url)
+
+	return nil
+}
+
+func (b *bot) resolveMessageMedia(msg *tbot.Message) (*media, error) {
+	r, _ := regexp.Compile(URLRegexPattern)
+	return b.mediaRegistry.Resolve(string(r.Find([]byte(msg.Text))))
 }
 
 func (b *bot) isAuthorizationRequired(upd *tbot.Update) bool {
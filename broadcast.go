@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	tbot "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"strings"
+	"sync"
+)
+
+const (
+	BroadcastAll       = "all"
+	BroadcastRoom      = "r"
+	BroadcastHousehold = "h"
+	BroadcastGroup     = "g"
+)
+
+// broadcastResult is the outcome of playing the media on the station through the session of the account it belongs to.
+type broadcastResult struct {
+	session *session
+	device  device
+	err     error
+}
+
+// broadcastRows returns keyboard rows to play the media on all stations, on a device group, in a room or in a household.
+// Rooms and households are only offered when they have more than one station. They are labeled with the account name if labeled is set.
+func broadcastRows(accounts []accountStations, labeled bool) [][]tbot.InlineKeyboardButton {
+	rows := make([][]tbot.InlineKeyboardButton, 0)
+	count := 0
+	for _, a := range accounts {
+		count += len(a.devices)
+	}
+	if count < 2 {
+		return rows
+	}
+
+	button := func(text string, target string) []tbot.InlineKeyboardButton {
+		return tbot.NewInlineKeyboardRow(tbot.NewInlineKeyboardButtonData(text, fmt.Sprintf("%s:%s", BroadcastCallback, target)))
+	}
+
+	rows = append(rows, button("Play on all stations", BroadcastAll))
+	for _, a := range accounts {
+		if a.info == nil {
+			continue
+		}
+
+		label := ""
+		if labeled {
+			label = fmt.Sprintf("[%s] ", a.session.activeAccountName())
+		}
+
+		for _, g := range stationGroups(a.info, a.devices) {
+			rows = append(rows, button(fmt.Sprintf("%s👥 %s", label, g.Name), fmt.Sprintf("%s:%s", BroadcastGroup, g.Id)))
+		}
+
+		for _, r := range a.info.Rooms {
+			if len(broadcastTargets(a.devices, a.info, BroadcastRoom, r.Id)) > 1 {
+				rows = append(rows, button(fmt.Sprintf("%sPlay in room %s", label, r.Name), fmt.Sprintf("%s:%s", BroadcastRoom, r.Id)))
+			}
+		}
+
+		if len(a.info.Households) < 2 {
+			continue
+		}
+		for _, h := range a.info.Households {
+			if len(broadcastTargets(a.devices, a.info, BroadcastHousehold, h.Id)) > 1 {
+				rows = append(rows, button(fmt.Sprintf("%sPlay in household %s", label, h.Name), fmt.Sprintf("%s:%s", BroadcastHousehold, h.Id)))
+			}
+		}
+	}
+
+	return rows
+}
+
+// broadcastTargets returns the stations of the room, household or group with the id. All stations are returned for BroadcastAll.
+func broadcastTargets(devices []device, info *iotInfo, scope string, id string) []device {
+	if scope == BroadcastAll {
+		return devices
+	}
+
+	if scope == BroadcastGroup {
+		targets := make([]device, 0)
+		for _, g := range stationGroups(info, devices) {
+			if g.Id == id {
+				for _, d := range devices {
+					if containsString(g.Devices, d.Id) {
+						targets = append(targets, d)
+					}
+				}
+			}
+		}
+
+		return targets
+	}
+
+	roomHouseholds := make(map[string]string)
+	for _, r := range info.Rooms {
+		roomHouseholds[r.Id] = r.HouseholdId
+	}
+
+	targets := make([]device, 0)
+	for _, d := range devices {
+		if scope == BroadcastRoom && d.Room == id ||
+			scope == BroadcastHousehold && roomHouseholds[d.Room] == id {
+			targets = append(targets, d)
+		}
+	}
+
+	return targets
+}
+
+// handleBroadcastCallback handles `all`, `r:<room id>`, `h:<household id>` and `g:<group id>` data.
+func (b *bot) handleBroadcastCallback(s *session, replyToMessage *tbot.Message, data string) {
+	m, err := b.resolveMessageMedia(replyToMessage)
+	if err != nil {
+		log.WithError(err).Error("Could not process callback. Error occurred trying to resolve media")
+		return
+	}
+
+	scope, id, _ := strings.Cut(data, ":")
+
+	b.handleError(s.chatId, b.broadcast(s, m, scope, id))
+}
+
+// broadcast plays the media on every target station concurrently and reports the result of each one.
+// Stations of all linked accounts are targeted if the session spans them.
+func (b *bot) broadcast(s *session, m *media, scope string, id string) error {
+	devices, err := b.getYandexStations(s)
+	if err != nil {
+		return err
+	}
+
+	info, err := b.yaClient.getYandexSmartHomeInfo(s)
+	if err != nil {
+		return NewBotError("Could not get list of registered devices. Please, try again.")
+	}
+
+	accounts := []accountStations{{s, devices, info}}
+	if s.spanAccounts {
+		accounts = append(accounts, b.spannedAccountStations(s)...)
+	}
+
+	targets := make([]broadcastResult, 0)
+	for _, a := range accounts {
+		// Rooms and groups of the account are not known without its smart home info
+		if a.info == nil && scope != BroadcastAll {
+			continue
+		}
+
+		accountTargets := broadcastTargets(a.devices, a.info, scope, id)
+		if len(accountTargets) == 0 {
+			continue
+		}
+
+		err = b.yaClient.refreshTokens(a.session)
+		if err != nil {
+			return err
+		}
+		for _, d := range accountTargets {
+			targets = append(targets, broadcastResult{session: a.session, device: d})
+		}
+	}
+	if len(targets) == 0 {
+		return NewBotError("I didn't find any yandex stations there. Are they configured properly?")
+	}
+
+	log.Infof("Broadcasting %s media %s to %d stations", m.provider.Name(), m.url, len(targets))
+
+	results := make([]broadcastResult, len(targets))
+	wg := sync.WaitGroup{}
+	for i, t := range targets {
+		b.queueTimers.Stop(s.chatId, t.device.Id)
+
+		wg.Add(1)
+		go func(i int, t broadcastResult) {
+			defer wg.Done()
+			t.err = b.yaClient.playMedia(t.session, &t.device, m)
+			results[i] = t
+		}(i, t)
+	}
+	wg.Wait()
+
+	buf := bytes.Buffer{}
+	buf.WriteString(fmt.Sprintf("Sharing %s:\n", m.url))
+	for _, r := range results {
+		var be *botError
+		switch {
+		case r.err == nil:
+			buf.WriteString(fmt.Sprintf("✅ %s\n", r.device.Name))
+		case errors.As(r.err, &be):
+			buf.WriteString(fmt.Sprintf("❌ %s: %s\n", r.device.Name, be.Error()))
+		default:
+			log.WithError(r.err).Errorf("Could not share media with device %s", r.device.Id)
+			buf.WriteString(fmt.Sprintf("❌ %s: something went wrong\n", r.device.Name))
+		}
+	}
+	b.send(s.chatId, buf.String())
+
+	return nil
+}
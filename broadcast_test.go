@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestBroadcastRowsSpanAccounts(t *testing.T) {
+	home := NewSession(sessionKey{1, 1}, NewToken("home", nil), nil, "")
+	work := NewSession(sessionKey{1, 1}, NewToken("work", nil), nil, "")
+	work.accountName = "work"
+
+	accounts := []accountStations{
+		{home, []device{{Id: "kitchen"}}, &iotInfo{}},
+		{work, []device{{Id: "office", Room: "r1"}, {Id: "hall", Room: "r1"}}, &iotInfo{Rooms: []room{{Id: "r1", Name: "Office"}}}},
+	}
+
+	var texts []string
+	for _, row := range broadcastRows(accounts, true) {
+		texts = append(texts, row[0].Text)
+	}
+
+	want := []string{"Play on all stations", "[work] Play in room Office"}
+	if len(texts) != len(want) {
+		t.Fatalf("broadcastRows() = %v, want %v", texts, want)
+	}
+	for i := range want {
+		if texts[i] != want[i] {
+			t.Errorf("broadcastRows() = %v, want %v", texts, want)
+		}
+	}
+
+	// There is nothing to broadcast to with a single station
+	if rows := broadcastRows(accounts[:1], false); len(rows) != 0 {
+		t.Errorf("broadcastRows() offers %d rows for a single station", len(rows))
+	}
+}
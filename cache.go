@@ -1,14 +1,28 @@
 package main
 
 import (
+	"fmt"
 	"github.com/patrickmn/go-cache"
+	"strings"
 	"time"
 )
 
+// CacheProvider implementations must be safe for concurrent use.
 type CacheProvider interface {
 	Save(key string, value interface{})
 	TryGet(key string) (interface{}, bool)
 	Delete(key string)
+	DeleteByPrefix(prefix string)
+}
+
+// chatCacheKey returns the key of the chat specific cache entry.
+func chatCacheKey(chatId int64, name string) string {
+	return fmt.Sprintf("%d_%s", chatId, name)
+}
+
+// chatCacheKeyPrefix returns the prefix shared by all cache entries of the chat.
+func chatCacheKeyPrefix(chatId int64) string {
+	return fmt.Sprintf("%d_", chatId)
 }
 
 type inMemoryCacheProvider struct {
@@ -30,3 +44,11 @@ func (p *inMemoryCacheProvider) TryGet(key string) (interface{}, bool) {
 func (p *inMemoryCacheProvider) Delete(key string) {
 	p.cache.Delete(key)
 }
+
+func (p *inMemoryCacheProvider) DeleteByPrefix(prefix string) {
+	for k := range p.cache.Items() {
+		if strings.HasPrefix(k, prefix) {
+			p.cache.Delete(k)
+		}
+	}
+}
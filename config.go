@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type botConfig struct {
+	telegramToken  string
+	yandexClientId string
+	// yandexClientSecret enables authorization code flow together with publicUrl. Optional
+	yandexClientSecret string
+	// publicUrl is the base URL the server is reachable at from the internet. Optional
+	publicUrl     string
+	webhookSecret string
+	workers       int
+	// tokenExpiryWarning is how long before OAuth token expiry it is refreshed or the user is warned
+	tokenExpiryWarning time.Duration
+	// youTubeApiKey enables shuffling and queueing of YouTube playlists. Optional
+	youTubeApiKey string
+	// allowedUsers holds users allowed to use telice. Nil means everyone is allowed
+	allowedUsers map[int64]bool
+	// adminIds holds users allowed to use admin commands. Admins are always allowed to use telice
+	adminIds map[int64]bool
+}
+
+func newBotConfig() *botConfig {
+	return &botConfig{
+		telegramToken:      os.Getenv(TelegramBotToken),
+		yandexClientId:     os.Getenv(YandexClientId),
+		yandexClientSecret: os.Getenv(YandexClientSecret),
+		publicUrl:          strings.TrimSuffix(os.Getenv(PublicUrlEnv), "/"),
+		webhookSecret:      webhookSecret(),
+		workers:            updateWorkers(),
+		tokenExpiryWarning: tokenExpiryWarning(),
+		youTubeApiKey:      os.Getenv(YouTubeApiKeyEnv),
+		allowedUsers:       allowedUsers(),
+		adminIds:           parseUserIds(AdminIdsEnv, strings.Split(os.Getenv(AdminIdsEnv), ",")),
+	}
+}
+
+// isAllowed reports whether the user may use telice.
+func (c *botConfig) isAllowed(userId int64) bool {
+	return c.allowedUsers == nil || c.allowedUsers[userId] || c.adminIds[userId]
+}
+
+// oauthRedirectUri returns the authorization code flow callback url or empty string if public url is not set.
+func (c *botConfig) oauthRedirectUri() string {
+	if c.publicUrl == "" {
+		return ""
+	}
+
+	return c.publicUrl + OAuthCallbackPath
+}
+
+func webhookSecret() string {
+	if v := os.Getenv(WebhookSecretEnv); v != "" {
+		return v
+	}
+
+	// Telegram accepts only A-Z, a-z, 0-9, _ and - characters in the secret token
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		log.WithError(err).Fatal("Could not generate webhook secret")
+	}
+
+	return hex.EncodeToString(buf)
+}
+
+func tokenExpiryWarning() time.Duration {
+	days := DefaultTokenExpiryWarningDays
+	if v := os.Getenv(TokenExpiryWarningDaysEnv); v != "" {
+		var err error
+		days, err = strconv.Atoi(v)
+		if err != nil || days < 1 {
+			log.Fatalf("%s must be a positive number", TokenExpiryWarningDaysEnv)
+		}
+	}
+
+	return time.Duration(days) * 24 * time.Hour
+}
+
+func allowedUsers() map[int64]bool {
+	ids, path := os.Getenv(AllowedUserIdsEnv), os.Getenv(AllowedUsersFileEnv)
+	if ids == "" && path == "" {
+		return nil
+	}
+
+	users := parseUserIds(AllowedUserIdsEnv, strings.Split(ids, ","))
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.WithError(err).Fatalf("Could not read allowed users file `%s`", path)
+		}
+
+		lines := make([]string, 0)
+		for _, l := range strings.Split(string(data), "\n") {
+			if !strings.HasPrefix(strings.TrimSpace(l), "#") {
+				lines = append(lines, l)
+			}
+		}
+		for id := range parseUserIds(AllowedUsersFileEnv, lines) {
+			users[id] = true
+		}
+	}
+
+	return users
+}
+
+// parseUserIds parses Telegram user ids skipping blank values.
+func parseUserIds(name string, values []string) map[int64]bool {
+	ids := make(map[int64]bool)
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id <= 0 {
+			log.Fatalf("%s must only contain Telegram user ids, got `%s`", name, v)
+		}
+		ids[id] = true
+	}
+
+	return ids
+}
+
+func updateWorkers() int {
+	v := os.Getenv(UpdateWorkersEnv)
+	if v == "" {
+		return DefaultUpdateWorkers
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		log.Fatalf("%s must be a positive number", UpdateWorkersEnv)
+	}
+
+	return n
+}
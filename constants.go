@@ -5,16 +5,88 @@ const (
 	PortEnv          = "PORT"
 	TelegramBotToken = "TELEGRAM_BOT_TOKEN"
 	YandexClientId   = "YANDEX_CLIENT_ID"
+	// YandexClientSecret enables OAuth authorization code flow when PublicUrlEnv is set as well
+	YandexClientSecret = "YANDEX_CLIENT_SECRET"
+
+	// SessionsDbPathEnv enables persistent sessions stored in the embedded database at the given path
+	SessionsDbPathEnv = "SESSIONS_DB_PATH"
+	// SessionsEncryptionKeyEnv is a base64 encoded 256-bit key used to encrypt stored OAuth tokens
+	SessionsEncryptionKeyEnv = "SESSIONS_ENCRYPTION_KEY"
+	// SessionsEncryptionOldKeysEnv is a comma separated list of retired keys kept to decrypt tokens after rotation
+	SessionsEncryptionOldKeysEnv = "SESSIONS_ENCRYPTION_OLD_KEYS"
+	// UpdateWorkersEnv is the number of workers processing updates concurrently
+	UpdateWorkersEnv = "UPDATE_WORKERS"
+	// PublicUrlEnv is the base URL telice is reachable at. Enables webhook mode
+	PublicUrlEnv = "PUBLIC_URL"
+	// WebhookSecretEnv is the secret token Telegram sends with webhook requests. Generated on start when not set
+	WebhookSecretEnv = "WEBHOOK_SECRET"
+	// TokenExpiryWarningDaysEnv is how many days before OAuth token expiry it is refreshed or the user is warned
+	TokenExpiryWarningDaysEnv = "TOKEN_EXPIRY_WARNING_DAYS"
+	// YouTubeApiKeyEnv is the YouTube Data API key used to fetch playlist videos
+	YouTubeApiKeyEnv = "YOUTUBE_API_KEY"
+	// AllowedUserIdsEnv is a comma separated list of Telegram user ids allowed to use telice. Everyone is allowed when
+	// neither it nor AllowedUsersFileEnv is set
+	AllowedUserIdsEnv = "ALLOWED_USER_IDS"
+	// AllowedUsersFileEnv is a path to a file with allowed Telegram user ids, one per line. Lines starting with # are ignored
+	AllowedUsersFileEnv = "ALLOWED_USERS_FILE"
+	// AdminIdsEnv is a comma separated list of Telegram user ids allowed to use admin commands
+	AdminIdsEnv = "ADMIN_IDS"
 
 	StartCmd           = "start"
 	ListDevicesCmd     = "listdevices"
 	SelectAsDefaultCmd = "selectasdefault"
 	ResetCmd           = "reset"
+	LogoutCmd          = "logout"
+	QueueCmd           = "queue"
+	NextCmd            = "next"
+	ClearQueueCmd      = "clear"
+	PauseCmd           = "pause"
+	ResumeCmd          = "resume"
+	StopCmd            = "stop"
+	VolumeCmd          = "volume"
+	SeekCmd            = "seek"
+	AtCmd              = "at"
+	InCmd              = "in"
+	ScheduledCmd       = "scheduled"
+	TimeZoneCmd        = "timezone"
+	SayCmd             = "say"
+	ScenariosCmd       = "scenarios"
+	DevicesCmd         = "devices"
+	HouseholdCmd       = "household"
+	AccountsCmd        = "accounts"
+	GroupCmd           = "group"
+	ShareCmd           = "share"
+
+	// Admin commands, see AdminIdsEnv
+	StatsCmd     = "stats"
+	UsersCmd     = "users"
+	BanCmd       = "ban"
+	UnbanCmd     = "unban"
+	BroadcastCmd = "broadcast"
 
-	SelectAsDefaultCallback  = "sad"
-	OneTimePlayMediaCallback = "otp"
+	SelectAsDefaultCallback      = "sad"
+	SelectGroupAsDefaultCallback = "sag"
+	OneTimePlayMediaCallback     = "otp"
+	PlaylistModeCallback         = "plm"
+	AddToQueueCallback           = "atq"
+	NextCallback                 = "nxt"
+	ClearQueueCallback           = "clq"
+	RemoteControlCallback        = "rc"
+	BroadcastCallback            = "bc"
+	ScheduleCallback             = "sch"
+	CancelScheduledCallback      = "csj"
+	SayCallback                  = "say"
+	ScenarioCallback             = "scn"
+	DeviceCallback               = "dvc"
+	DeviceActionCallback         = "dva"
+	HouseholdCallback            = "hh"
+	AccountCallback              = "acc"
+	GroupCallback                = "grp"
+	ShareCallback                = "shr"
 
 	YandexStationTypeSubstr = "yandex.station"
+	// DefaultAccountName is the name of the Yandex account authorized first
+	DefaultAccountName = "main"
 
 	URLRegexPattern = "(?:(?:https?):\\/\\/|\\b(?:[a-z\\d]+\\.))(?:(?:[^\\s()<>]+|\\((?:[^\\s()<>]+|(?:\\([^\\s()<>]+\\)))?\\))+(?:\\((?:[^\\s()<>]+|(?:\\(?:[^\\s()<>]+\\)))?\\)|[^\\s`!()\\[\\]{};:'\".,<>?«»“”‘’]))?"
 )
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	tbot "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	onOffCapabilityType        = "devices.capabilities.on_off"
+	rangeCapabilityType        = "devices.capabilities.range"
+	colorSettingCapabilityType = "devices.capabilities.color_setting"
+	floatPropertyType          = "devices.properties.float"
+
+	brightnessInstance   = "brightness"
+	temperatureInstance  = "temperature"
+	temperatureKInstance = "temperature_k"
+)
+
+// Device actions passed with DeviceActionCallback
+const (
+	turnOnAction           = "on"
+	turnOffAction          = "off"
+	brightnessAction       = "br"
+	temperatureAction      = "tp"
+	colorTemperatureAction = "ck"
+)
+
+func findCapability(d *device, capabilityType string, instance string) *capability {
+	for i, c := range d.Capabilities {
+		if c.Type == capabilityType && (instance == "" || c.Parameters.Instance == instance) {
+			return &d.Capabilities[i]
+		}
+	}
+
+	return nil
+}
+
+// formatDeviceState returns the current state of the device, e.g. `on, brightness 50%, temperature 21°C`.
+func formatDeviceState(d *device) string {
+	parts := make([]string, 0)
+	for _, c := range d.Capabilities {
+		if c.State == nil {
+			continue
+		}
+
+		switch c.Type {
+		case onOffCapabilityType:
+			if on, _ := c.State.Value.(bool); on {
+				parts = append(parts, "on")
+			} else {
+				parts = append(parts, "off")
+			}
+		case rangeCapabilityType:
+			parts = append(parts, fmt.Sprintf("%s %v%s", c.State.Instance, c.State.Value, unitSuffix(c.Parameters.Unit)))
+		case colorSettingCapabilityType:
+			if c.State.Instance == temperatureKInstance {
+				parts = append(parts, fmt.Sprintf("%vK", c.State.Value))
+			}
+		}
+	}
+	for _, p := range d.Properties {
+		if p.Type == floatPropertyType && p.State != nil {
+			parts = append(parts, fmt.Sprintf("%s %v%s", p.State.Instance, p.State.Value, unitSuffix(p.Parameters.Unit)))
+		}
+	}
+
+	if len(parts) == 0 {
+		return "state is unknown"
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+func unitSuffix(unit string) string {
+	switch unit {
+	case "unit.percent":
+		return "%"
+	case "unit.temperature.celsius":
+		return "°C"
+	case "unit.temperature.kelvin":
+		return "K"
+	}
+
+	return ""
+}
+
+// deviceControlKeyboard returns buttons for the capabilities of the device telice knows how to control.
+func deviceControlKeyboard(d *device) [][]tbot.InlineKeyboardButton {
+	button := func(text string, action string, value float64) tbot.InlineKeyboardButton {
+		data := fmt.Sprintf("%s:%s:%s", DeviceActionCallback, d.Id, action)
+		if action != turnOnAction && action != turnOffAction {
+			data = fmt.Sprintf("%s:%s", data, strconv.FormatFloat(value, 'f', -1, 64))
+		}
+
+		return tbot.NewInlineKeyboardButtonData(text, data)
+	}
+
+	rows := make([][]tbot.InlineKeyboardButton, 0)
+	if findCapability(d, onOffCapabilityType, "") != nil {
+		rows = append(rows, tbot.NewInlineKeyboardRow(
+			button("Turn on", turnOnAction, 0),
+			button("Turn off", turnOffAction, 0),
+		))
+	}
+
+	if c := findCapability(d, rangeCapabilityType, brightnessInstance); c != nil && c.Parameters.Range != nil {
+		row := make([]tbot.InlineKeyboardButton, 0)
+		for _, v := range []float64{10, 50, 100} {
+			v = clamp(v, c.Parameters.Range)
+			row = append(row, button(fmt.Sprintf("💡 %v%%", v), brightnessAction, v))
+		}
+		rows = append(rows, row)
+	}
+
+	if c := findCapability(d, rangeCapabilityType, temperatureInstance); c != nil && c.Parameters.Range != nil {
+		r := c.Parameters.Range
+
+		current := math.Round((r.Min + r.Max) / 2)
+		if c.State != nil {
+			if v, ok := c.State.Value.(float64); ok {
+				current = v
+			}
+		}
+		step := r.Precision
+		if step <= 0 {
+			step = 1
+		}
+
+		lower, higher := clamp(current-step, r), clamp(current+step, r)
+		rows = append(rows, tbot.NewInlineKeyboardRow(
+			button(fmt.Sprintf("🌡 %v°", lower), temperatureAction, lower),
+			button(fmt.Sprintf("🌡 %v°", higher), temperatureAction, higher),
+		))
+	}
+
+	if c := findCapability(d, colorSettingCapabilityType, ""); c != nil && c.Parameters.TemperatureK != nil {
+		r := c.Parameters.TemperatureK
+		rows = append(rows, tbot.NewInlineKeyboardRow(
+			button("Warm", colorTemperatureAction, r.Min),
+			button("Neutral", colorTemperatureAction, clamp(4500, r)),
+			button("Cold", colorTemperatureAction, r.Max),
+		))
+	}
+
+	return rows
+}
+
+func clamp(v float64, r *valueRange) float64 {
+	return math.Min(math.Max(v, r.Min), r.Max)
+}
+
+// newDeviceAction converts the action passed with DeviceActionCallback to IoT API device action.
+func newDeviceAction(action string, value string) (deviceAction, error) {
+	switch action {
+	case turnOnAction, turnOffAction:
+		return deviceAction{onOffCapabilityType, capabilityState{"on", action == turnOnAction}}, nil
+	}
+
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return deviceAction{}, fmt.Errorf("invalid value `%s` of action %s", value, action)
+	}
+
+	switch action {
+	case brightnessAction:
+		return deviceAction{rangeCapabilityType, capabilityState{brightnessInstance, v}}, nil
+	case temperatureAction:
+		return deviceAction{rangeCapabilityType, capabilityState{temperatureInstance, v}}, nil
+	case colorTemperatureAction:
+		return deviceAction{colorSettingCapabilityType, capabilityState{temperatureKInstance, v}}, nil
+	}
+
+	return deviceAction{}, fmt.Errorf("unknown device action %s", action)
+}
+
+func (b *bot) handleDevicesCommand(s *session) error {
+	// Device states are only useful when they are fresh
+	b.yaClient.invalidateSmartHomeInfo(s)
+
+	devices, err := b.yaClient.getSmartHomeDevices(s)
+	if err != nil {
+		return err
+	}
+	devices = b.filterActiveHousehold(s, devices)
+	if len(devices) == 0 {
+		return NewBotError("I didn't find any smart home devices except for yandex stations.")
+	}
+
+	iotInfo, _ := b.yaClient.getYandexSmartHomeInfo(s)
+
+	buf := bytes.Buffer{}
+	rows := make([][]tbot.InlineKeyboardButton, 0)
+	for i, d := range devices {
+		var r room
+		for _, v := range iotInfo.Rooms {
+			if v.Id == d.Room {
+				r = v
+				break
+			}
+		}
+
+		buf.WriteString(fmt.Sprintf("%d. %s - %s: %s\n", i+1, r.Name, d.Name, formatDeviceState(&d)))
+
+		if len(deviceControlKeyboard(&d)) > 0 {
+			data := fmt.Sprintf("%s:%s", DeviceCallback, d.Id)
+			rows = append(rows, tbot.NewInlineKeyboardRow(tbot.NewInlineKeyboardButtonData(fmt.Sprintf("⚙️ %s", d.Name), data)))
+		}
+	}
+
+	msg := tbot.NewMessage(s.chatId, buf.String())
+	if len(rows) > 0 {
+		msg.ReplyMarkup = tbot.NewInlineKeyboardMarkup(rows...)
+	}
+
+	//goland:noinspection GoUnhandledErrorResult
+	b.api.Send(msg)
+
+	return nil
+}
+
+// findSmartHomeDevice returns the device with the id or botError if it is not available.
+func (b *bot) findSmartHomeDevice(s *session, deviceId string) (*device, error) {
+	devices, err := b.yaClient.getSmartHomeDevices(s)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, d := range devices {
+		if d.Id == deviceId {
+			return &d, nil
+		}
+	}
+
+	return nil, NewBotError("Selected device is not currently available. Please, try again later.")
+}
+
+func (b *bot) handleDeviceCallback(s *session, deviceId string) {
+	d, err := b.findSmartHomeDevice(s, deviceId)
+	if err != nil {
+		b.handleError(s.chatId, err)
+		return
+	}
+
+	msg := tbot.NewMessage(s.chatId, fmt.Sprintf("`%s`: %s", d.Name, formatDeviceState(d)))
+	msg.ReplyMarkup = tbot.NewInlineKeyboardMarkup(deviceControlKeyboard(d)...)
+
+	//goland:noinspection GoUnhandledErrorResult
+	b.api.Send(msg)
+}
+
+// handleDeviceActionCallback handles `<device id>:<action>[:<value>]` data.
+func (b *bot) handleDeviceActionCallback(s *session, data string) {
+	deviceId, rest, _ := strings.Cut(data, ":")
+	action, value, _ := strings.Cut(rest, ":")
+
+	a, err := newDeviceAction(action, value)
+	if err != nil {
+		log.WithError(err).Error("Could not process callback")
+		return
+	}
+
+	d, err := b.findSmartHomeDevice(s, deviceId)
+	if err != nil {
+		b.handleError(s.chatId, err)
+		return
+	}
+
+	resp, err := b.yaClient.runDeviceAction(s, d.Id, a)
+	if err != nil {
+		log.WithError(err).Errorf("Could not run action %s on device %s", action, d.Id)
+		b.send(s.chatId, fmt.Sprintf("Could not control `%s`. Please, try again later.", d.Name))
+		return
+	}
+
+	if resp.Status != "ok" {
+		log.Errorf("Device action has been refused. Request: %s. Message: %s", resp.RequestId, resp.Message)
+		b.send(s.chatId, fmt.Sprintf("Yandex has refused to control `%s`: %s", d.Name, resp.Message))
+		return
+	}
+
+	for _, rd := range resp.Devices {
+		for _, c := range rd.Capabilities {
+			if r := c.State.ActionResult; r.Status == "ERROR" {
+				reason := r.ErrorMessage
+				if reason == "" {
+					reason = r.ErrorCode
+				}
+				b.send(s.chatId, fmt.Sprintf("`%s` could not do it: %s", d.Name, reason))
+				return
+			}
+		}
+	}
+
+	if d, err = b.findSmartHomeDevice(s, deviceId); err == nil {
+		b.send(s.chatId, fmt.Sprintf("Done! `%s`: %s", d.Name, formatDeviceState(d)))
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	tbot "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"sync"
+)
+
+const (
+	DefaultUpdateWorkers = 8
+
+	workerQueueSize = 100
+)
+
+// dispatcher processes updates on a bounded pool of workers.
+// Updates and jobs for the same chat are always routed to the same worker,
+// so they are handled one at a time in the order they were received.
+type dispatcher struct {
+	queues []chan func()
+	handle func(update tbot.Update)
+	wg     sync.WaitGroup
+	// jobs counts enqueued jobs until they are run, including the ones waiting for room in a queue
+	jobs sync.WaitGroup
+}
+
+func newDispatcher(workers int, handle func(update tbot.Update)) *dispatcher {
+	if workers < 1 {
+		workers = 1
+	}
+
+	d := &dispatcher{
+		queues: make([]chan func(), workers),
+		handle: handle,
+	}
+	for i := range d.queues {
+		d.queues[i] = make(chan func(), workerQueueSize)
+
+		d.wg.Add(1)
+		go d.work(d.queues[i])
+	}
+
+	return d
+}
+
+func (d *dispatcher) work(queue <-chan func()) {
+	defer d.wg.Done()
+
+	for job := range queue {
+		d.safeRun(job)
+		d.jobs.Done()
+	}
+}
+
+func (d *dispatcher) safeRun(job func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("Recovered from panic while processing a job: %v", r)
+		}
+	}()
+
+	job()
+}
+
+// Dispatch enqueues the update. It blocks when the worker responsible for the chat is busy and its queue is full.
+func (d *dispatcher) Dispatch(update tbot.Update) {
+	var chatId int64
+	if chat := update.FromChat(); chat != nil {
+		chatId = chat.ID
+	}
+
+	d.Enqueue(chatId, func() {
+		d.handle(update)
+	})
+}
+
+// Enqueue schedules the job on the worker responsible for the chat.
+// Use it to modify the chat session outside of update handling.
+func (d *dispatcher) Enqueue(chatId int64, job func()) {
+	d.jobs.Add(1)
+	d.queue(chatId) <- job
+}
+
+// EnqueueAsync schedules the job on the worker responsible for the chat without waiting for room in its queue.
+// Use it to modify a session of another chat from a worker: waiting for a busy worker, which may in turn wait
+// for this one, would deadlock both. Jobs enqueued this way may run in any order relative to each other.
+func (d *dispatcher) EnqueueAsync(chatId int64, job func()) {
+	d.jobs.Add(1)
+	go func() {
+		d.queue(chatId) <- job
+	}()
+}
+
+func (d *dispatcher) queue(chatId int64) chan<- func() {
+	idx := chatId % int64(len(d.queues))
+	if idx < 0 {
+		idx = -idx
+	}
+
+	return d.queues[idx]
+}
+
+// Stop waits for all enqueued updates to be processed.
+// Queues are closed once jobs enqueued by other jobs have run as well.
+func (d *dispatcher) Stop() {
+	d.jobs.Wait()
+	for _, q := range d.queues {
+		close(q)
+	}
+	d.wg.Wait()
+}
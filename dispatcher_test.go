@@ -0,0 +1,110 @@
+package main
+
+import (
+	tbot "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"sync"
+	"testing"
+)
+
+func TestDispatcherKeepsPerChatOrder(t *testing.T) {
+	const chats, updatesPerChat = 16, 200
+
+	var mu sync.Mutex
+	handled := make(map[int64][]int)
+	record := func(chatId int64, seq int) {
+		mu.Lock()
+		defer mu.Unlock()
+		handled[chatId] = append(handled[chatId], seq)
+	}
+
+	d := newDispatcher(4, func(update tbot.Update) {
+		record(update.Message.Chat.ID, update.UpdateID)
+	})
+
+	// Updates of different chats are interleaved and jobs are enqueued between them, the way timers do
+	for i := 0; i < updatesPerChat; i++ {
+		for c := int64(0); c < chats; c++ {
+			chatId := c - chats/2
+			seq := 2 * i
+			d.Dispatch(tbot.Update{UpdateID: seq, Message: &tbot.Message{Chat: &tbot.Chat{ID: chatId}}})
+			d.Enqueue(chatId, func() {
+				record(chatId, seq+1)
+			})
+		}
+	}
+	d.Stop()
+
+	if len(handled) != chats {
+		t.Fatalf("updates of %d chats are handled, want %d", len(handled), chats)
+	}
+	for chatId, seqs := range handled {
+		if len(seqs) != 2*updatesPerChat {
+			t.Errorf("chat %d: %d updates are handled, want %d", chatId, len(seqs), 2*updatesPerChat)
+			continue
+		}
+		for i, seq := range seqs {
+			if seq != i {
+				t.Errorf("chat %d: update %d is handled at position %d", chatId, seq, i)
+				break
+			}
+		}
+	}
+}
+
+func TestDispatcherRecoversFromPanics(t *testing.T) {
+	var mu sync.Mutex
+	handled := 0
+
+	d := newDispatcher(1, func(update tbot.Update) {
+		if update.UpdateID == 0 {
+			panic("handler failed")
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		handled++
+	})
+	d.Dispatch(tbot.Update{UpdateID: 0, Message: &tbot.Message{Chat: &tbot.Chat{ID: 1}}})
+	d.Dispatch(tbot.Update{UpdateID: 1, Message: &tbot.Message{Chat: &tbot.Chat{ID: 1}}})
+	d.Stop()
+
+	if handled != 1 {
+		t.Errorf("%d updates are handled after the panic, want 1", handled)
+	}
+}
+
+// Workers enqueueing jobs to each other must not wait for room in the queues, or they would deadlock once both are full.
+func TestDispatcherEnqueueAsyncAcrossChats(t *testing.T) {
+	const jobs = 3 * workerQueueSize
+
+	var mu sync.Mutex
+	handled := make(map[int64]int)
+	record := func(chatId int64) {
+		mu.Lock()
+		defer mu.Unlock()
+		handled[chatId]++
+	}
+
+	d := newDispatcher(2, func(tbot.Update) {})
+	for _, chatId := range []int64{0, 1} {
+		from, to := chatId, 1-chatId
+		d.Enqueue(from, func() {
+			for i := 0; i < jobs; i++ {
+				d.EnqueueAsync(to, func() {
+					record(to)
+					// Jobs enqueued by jobs are run before the dispatcher stops
+					d.EnqueueAsync(from, func() {
+						record(from)
+					})
+				})
+			}
+		})
+	}
+	d.Stop()
+
+	for _, chatId := range []int64{0, 1} {
+		if handled[chatId] != 2*jobs {
+			t.Errorf("chat %d: %d jobs are run, want %d", chatId, handled[chatId], 2*jobs)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	DefaultTokenExpiryWarningDays = 7
+
+	tokensExpiryCheckInterval = time.Hour
+)
+
+// watchTokensExpiry periodically refreshes OAuth tokens that are about to expire.
+// Users whose tokens cannot be refreshed are asked to log in again.
+func (b *bot) watchTokensExpiry() {
+	ticker := time.NewTicker(tokensExpiryCheckInterval)
+	defer ticker.Stop()
+
+	for ; true; <-ticker.C {
+		for _, key := range b.sessionProvider.Keys() {
+			key := key
+			b.dispatcher.Enqueue(key.chatId, func() {
+				b.checkTokenExpiry(key)
+			})
+		}
+	}
+}
+
+func (b *bot) checkTokenExpiry(key sessionKey) {
+	// Guests use the token of the owner, it is checked with the owner session
+	s, ok := b.sessionProvider.TryGet(key)
+	if !ok || s.grantedBy != 0 {
+		return
+	}
+	chatId := key.chatId
+
+	for _, a := range s.accounts {
+		s = b.checkAccountTokenExpiry(s, a)
+	}
+
+	if s.oauthToken == nil || s.oauthToken.expiresAt == nil {
+		return
+	}
+
+	expiresAt := *s.oauthToken.expiresAt
+	if time.Until(expiresAt) > b.cfg.tokenExpiryWarning {
+		return
+	}
+
+	if s.refreshToken != "" && b.yaClient.isCodeFlowEnabled() {
+		oauthToken, refreshToken, err := b.yaClient.refreshOAuthToken(s.refreshToken)
+		if err == nil {
+			b.sessionProvider.SaveOrUpdate(NewSessionWithTokens(s, oauthToken, nil, refreshToken))
+			log.Infof("OAuth token for chat %d has been refreshed", chatId)
+			return
+		}
+
+		log.WithError(err).Errorf("Could not refresh OAuth token for chat %d", chatId)
+	}
+
+	if s.expiryNotifiedAt != nil {
+		return
+	}
+
+	text := fmt.Sprintf("Your Yandex authorization expires on %s. Please, log in again using the link down below to keep using telice.",
+		expiresAt.Format("2 Jan 2006"))
+	if time.Now().After(expiresAt) {
+		text = "Your Yandex authorization has expired. Please, log in again using the link down below to keep using telice."
+	}
+	if err := b.sendLoginUrl(key, text); err != nil {
+		log.WithError(err).Errorf("Could not send login url for chat %d to user %d", chatId, key.userId)
+		return
+	}
+
+	now := time.Now().UTC()
+	s.expiryNotifiedAt = &now
+	b.sessionProvider.SaveOrUpdate(s)
+}
+
+// checkAccountTokenExpiry refreshes the token of the linked account that is not active.
+// If it cannot be refreshed, the user is asked to switch to the account and log in again.
+func (b *bot) checkAccountTokenExpiry(s *session, a account) *session {
+	if a.oauthToken == nil || a.oauthToken.expiresAt == nil {
+		return s
+	}
+
+	expiresAt := *a.oauthToken.expiresAt
+	if time.Until(expiresAt) > b.cfg.tokenExpiryWarning {
+		return s
+	}
+
+	if a.refreshToken != "" && b.yaClient.isCodeFlowEnabled() {
+		oauthToken, refreshToken, err := b.yaClient.refreshOAuthToken(a.refreshToken)
+		if err == nil {
+			a.oauthToken, a.refreshToken, a.expiryNotifiedAt = oauthToken, refreshToken, nil
+			s = NewSessionWithUpdatedAccount(s, a)
+			b.sessionProvider.SaveOrUpdate(s)
+			log.Infof("OAuth token of account %s for chat %d has been refreshed", a.name, s.chatId)
+			return s
+		}
+
+		log.WithError(err).Errorf("Could not refresh OAuth token of account %s for chat %d", a.name, s.chatId)
+	}
+
+	if a.expiryNotifiedAt != nil {
+		return s
+	}
+
+	b.send(s.chatId, fmt.Sprintf("Yandex authorization of account `%s` expires on %s. "+
+		"Please, switch to it with /%s and log in again to keep using its stations.",
+		a.name, expiresAt.Format("2 Jan 2006"), AccountsCmd))
+
+	now := time.Now().UTC()
+	a.expiryNotifiedAt = &now
+	s = NewSessionWithUpdatedAccount(s, a)
+	b.sessionProvider.SaveOrUpdate(s)
+
+	return s
+}
@@ -8,6 +8,7 @@ require (
 	github.com/go-telegram-bot-api/telegram-bot-api/v5 v5.5.1
 	github.com/patrickmn/go-cache v2.1.0+incompatible
 	github.com/sirupsen/logrus v1.8.1
+	go.etcd.io/bbolt v1.3.6
 )
 
 require golang.org/x/sys v0.0.0-20220615213510-4f61da869c0c // indirect
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	tbot "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// permission is a set of things members of a group chat may do with the stations shared with the chat, see /group.
+type permission int
+
+const (
+	playPermission permission = 1 << iota
+	controlPermission
+	smartHomePermission
+
+	allPermissions = playPermission | controlPermission | smartHomePermission
+)
+
+var permissionNames = []struct {
+	permission permission
+	name       string
+}{
+	{playPermission, "play media"},
+	{controlPermission, "control playback"},
+	{smartHomePermission, "use Smart Home"},
+}
+
+// commandPermissions holds the permissions members need to use the commands. Any of them is enough.
+// Commands missing here are only available to the owner of the stations.
+var commandPermissions = map[string]permission{
+	ListDevicesCmd: playPermission | controlPermission,
+	QueueCmd:       playPermission | controlPermission,
+	NextCmd:        controlPermission,
+	ClearQueueCmd:  controlPermission,
+	PauseCmd:       controlPermission,
+	ResumeCmd:      controlPermission,
+	StopCmd:        controlPermission,
+	VolumeCmd:      controlPermission,
+	SeekCmd:        controlPermission,
+	AtCmd:          playPermission,
+	InCmd:          playPermission,
+	ScheduledCmd:   playPermission,
+	SayCmd:         playPermission,
+	ScenariosCmd:   smartHomePermission,
+	DevicesCmd:     smartHomePermission,
+}
+
+// callbackPermissions holds the permissions members need to press the buttons. See commandPermissions.
+var callbackPermissions = map[string]permission{
+	OneTimePlayMediaCallback: playPermission,
+	PlaylistModeCallback:     playPermission,
+	AddToQueueCallback:       playPermission,
+	BroadcastCallback:        playPermission,
+	ScheduleCallback:         playPermission,
+	CancelScheduledCallback:  playPermission,
+	SayCallback:              playPermission,
+	NextCallback:             controlPermission,
+	ClearQueueCallback:       controlPermission,
+	RemoteControlCallback:    controlPermission,
+	ScenarioCallback:         smartHomePermission,
+	DeviceCallback:           smartHomePermission,
+	DeviceActionCallback:     smartHomePermission,
+}
+
+func (p permission) String() string {
+	names := make([]string, 0)
+	for _, n := range permissionNames {
+		if p&n.permission != 0 {
+			names = append(names, n.name)
+		}
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// updateSessionKey returns the key of the session of the user who has sent the update.
+func updateSessionKey(chat *tbot.Chat, user *tbot.User) sessionKey {
+	if chat.IsPrivate() {
+		return sessionKey{chat.ID, chat.ID}
+	}
+
+	return sessionKey{chat.ID, user.ID}
+}
+
+// requiredPermission returns the permissions a member needs for the update. Zero means only the owner may do it.
+func requiredPermission(update *tbot.Update) permission {
+	if update.CallbackQuery != nil {
+		method, _, _ := strings.Cut(update.CallbackQuery.Data, ":")
+		return callbackPermissions[method]
+	}
+
+	if update.Message.IsCommand() {
+		return commandPermissions[update.Message.Command()]
+	}
+
+	return playPermission
+}
+
+// isGroupChatter reports whether the update is a group chat message that is not meant for telice,
+// i.e. it is neither a command nor contains a link.
+func isGroupChatter(update *tbot.Update) bool {
+	if update.Message == nil || update.Message.IsCommand() {
+		return false
+	}
+
+	r, _ := regexp.Compile(URLRegexPattern)
+	return !r.MatchString(update.Message.Text)
+}
+
+// findSharedSession returns the session of the member who shares their stations with the group chat.
+func (b *bot) findSharedSession(chatId int64) (*session, bool) {
+	k, ok := b.sessionProvider.SharedKey(chatId)
+	if !ok {
+		return nil, false
+	}
+
+	s, ok := b.sessionProvider.TryGet(k)
+	if !ok || s.sharedPermissions == 0 {
+		return nil, false
+	}
+
+	return s, true
+}
+
+// sharedSession returns the session members of the group chat without a session of their own act on behalf of.
+// botError is returned if the stations are not shared or the member is not permitted to do what they want.
+func (b *bot) sharedSession(chatId int64, required permission) (*session, error) {
+	s, ok := b.findSharedSession(chatId)
+	if !ok {
+		return nil, NewBotError(fmt.Sprintf("Nobody shares their stations with this chat. "+
+			"Please, log in with /%s to use your own or ask the owner of the stations to share them with /%s.", StartCmd, GroupCmd))
+	}
+
+	if s.sharedPermissions&required == 0 {
+		return nil, NewBotError(fmt.Sprintf("Sorry, you are not allowed to do that. Members of this chat may %s. "+
+			"Please, ask the owner of the stations to permit it with /%s.", s.sharedPermissions, GroupCmd))
+	}
+
+	return s, nil
+}
+
+// handleGroupCommand shows what members of the group chat may do with the stations of the session.
+func (b *bot) handleGroupCommand(s *session) error {
+	if !s.key().isGroup() {
+		return NewBotError("This command is only available in group chats.")
+	}
+
+	if owner, ok := b.findSharedSession(s.chatId); ok && owner.userId != s.userId {
+		return NewBotError("Another member already shares their stations with this chat.")
+	}
+
+	rows := make([][]tbot.InlineKeyboardButton, 0)
+	for _, n := range permissionNames {
+		// Every button toggles a single permission keeping the rest of them
+		text, value := "❌ "+n.name, s.sharedPermissions|n.permission
+		if s.sharedPermissions&n.permission != 0 {
+			text, value = "✅ "+n.name, s.sharedPermissions&^n.permission
+		}
+		rows = append(rows, tbot.NewInlineKeyboardRow(tbot.NewInlineKeyboardButtonData(text, fmt.Sprintf("%s:%d:%d", GroupCallback, s.userId, value))))
+	}
+
+	text := "Your stations are not shared with this chat. Choose what other members may do to share them."
+	if s.sharedPermissions != 0 {
+		text = fmt.Sprintf("Members of this chat without a Yandex login may %s with your stations.", s.sharedPermissions)
+		rows = append(rows, tbot.NewInlineKeyboardRow(tbot.NewInlineKeyboardButtonData("Stop sharing", fmt.Sprintf("%s:%d:0", GroupCallback, s.userId))))
+	}
+
+	msg := tbot.NewMessage(s.chatId, text)
+	msg.ReplyMarkup = tbot.NewInlineKeyboardMarkup(rows...)
+
+	//goland:noinspection GoUnhandledErrorResult
+	b.api.Send(msg)
+
+	return nil
+}
+
+// handleGroupCallback handles `<user id>:<permissions>` data. Permissions are only replaced if the keyboard has been
+// shown to the user who has pressed the button, so members cannot share their stations by accident.
+func (b *bot) handleGroupCallback(s *session, data string) {
+	userId, value, _ := strings.Cut(data, ":")
+	if userId != strconv.FormatInt(s.userId, 10) {
+		b.send(s.chatId, fmt.Sprintf("These buttons are meant for another member. Use /%s to share your stations.", GroupCmd))
+		return
+	}
+
+	v, err := strconv.Atoi(value)
+	if err != nil || v < 0 || permission(v)&^allPermissions != 0 {
+		log.WithError(err).Errorf("Could not process callback. Invalid permissions `%s`", value)
+		return
+	}
+	permissions := permission(v)
+
+	if owner, ok := b.findSharedSession(s.chatId); ok && owner.userId != s.userId {
+		b.send(s.chatId, "Another member already shares their stations with this chat.")
+		return
+	}
+
+	b.sessionProvider.SaveOrUpdate(NewSessionWithSharedPermissions(s, permissions))
+
+	if permissions == 0 {
+		b.send(s.chatId, "Your stations are not shared with this chat anymore.")
+		return
+	}
+
+	b.send(s.chatId, fmt.Sprintf("Done! Members of this chat may %s with your stations.", permissions))
+}
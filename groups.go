@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// stationGroups returns device groups having yandex stations. Group devices are limited to the stations.
+func stationGroups(info *iotInfo, stations []device) []group {
+	groups := make([]group, 0)
+	if info == nil {
+		return groups
+	}
+
+	for _, g := range info.Groups {
+		members := make([]string, 0)
+		for _, d := range stations {
+			if containsString(g.Devices, d.Id) {
+				members = append(members, d.Id)
+			}
+		}
+		if len(members) == 0 {
+			continue
+		}
+
+		g.Devices = members
+		groups = append(groups, g)
+	}
+
+	return groups
+}
+
+func containsString(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+// formatStationGroups lists the groups with their stations for /listdevices. Returns empty string if there are no groups.
+func formatStationGroups(s *session, groups []group, stations []device) string {
+	if len(groups) == 0 {
+		return ""
+	}
+
+	names := make(map[string]string, len(stations))
+	for _, d := range stations {
+		names[d.Id] = d.Name
+	}
+
+	buf := bytes.Buffer{}
+	buf.WriteString("\nGroups:\n")
+	for i, g := range groups {
+		prefix := ""
+		if s.defaultGroupId == g.Id {
+			prefix = "Default: "
+		}
+
+		buf.WriteString(fmt.Sprintf("%d. %s%s -", i+1, prefix, g.Name))
+		for j, id := range g.Devices {
+			if j > 0 {
+				buf.WriteString(",")
+			}
+			buf.WriteString(" " + names[id])
+		}
+		buf.WriteString("\n")
+	}
+
+	return buf.String()
+}
+
+func (b *bot) handleSelectGroupAsDefaultCallback(s *session, groupId string) {
+	// Only stations of the active household may be selected
+	devices, err := b.getYandexStations(s)
+	if err != nil {
+		b.handleError(s.chatId, err)
+		return
+	}
+
+	iotInfo, err := b.yaClient.getYandexSmartHomeInfo(s)
+	if err != nil {
+		log.WithError(err).Error("Could not process callback. Error occurred trying to get smart home info")
+		return
+	}
+
+	for _, g := range stationGroups(iotInfo, devices) {
+		if g.Id == groupId {
+			b.sessionProvider.SaveOrUpdate(NewSessionWithGroup(s, g.Id))
+
+			b.send(s.chatId, fmt.Sprintf("Nice! Group `%s` is selected as default. Media will be played on all of its stations. Commands controlling a single station, such as /%s or /%s, will still ask you to select one.", g.Name, PauseCmd, SayCmd))
+			return
+		}
+	}
+
+	b.send(s.chatId, "Selected group is not currently available. Please, try again later.")
+}
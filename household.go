@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	tbot "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// deviceHouseholdId returns the household of the device falling back to the household of its room.
+func deviceHouseholdId(info *iotInfo, d *device) string {
+	if d.HouseholdId != "" {
+		return d.HouseholdId
+	}
+
+	for _, r := range info.Rooms {
+		if r.Id == d.Room {
+			return r.HouseholdId
+		}
+	}
+
+	return ""
+}
+
+// filterActiveHousehold returns the devices of the household selected with /household. All devices are returned if none is selected.
+func (b *bot) filterActiveHousehold(s *session, devices []device) []device {
+	if s.householdId == "" {
+		return devices
+	}
+
+	info, err := b.yaClient.getYandexSmartHomeInfo(s)
+	if err != nil {
+		return devices
+	}
+
+	filtered := make([]device, 0, len(devices))
+	for _, d := range devices {
+		if deviceHouseholdId(info, &d) == s.householdId {
+			filtered = append(filtered, d)
+		}
+	}
+
+	return filtered
+}
+
+func (b *bot) handleHouseholdCommand(s *session) error {
+	iotInfo, err := b.yaClient.getYandexSmartHomeInfo(s)
+	if err != nil {
+		return NewBotError("Could not get list of households. Please, try again.")
+	}
+	if len(iotInfo.Households) < 2 {
+		return NewBotError("You have only one household, so there is nothing to choose from.")
+	}
+
+	button := func(text string, householdId string, active bool) []tbot.InlineKeyboardButton {
+		if active {
+			text = "✓ " + text
+		}
+		// Empty id selects all households
+		return tbot.NewInlineKeyboardRow(tbot.NewInlineKeyboardButtonData(text, fmt.Sprintf("%s:%s", HouseholdCallback, householdId)))
+	}
+
+	rows := make([][]tbot.InlineKeyboardButton, 0)
+	for _, h := range iotInfo.Households {
+		rows = append(rows, button(h.Name, h.Id, s.householdId == h.Id))
+	}
+	rows = append(rows, button("All households", "", s.householdId == ""))
+
+	msg := tbot.NewMessage(s.chatId, "Please, select the household you want to use. Only its devices will be shown.")
+	msg.ReplyMarkup = tbot.NewInlineKeyboardMarkup(rows...)
+
+	//goland:noinspection GoUnhandledErrorResult
+	b.api.Send(msg)
+
+	return nil
+}
+
+// handleHouseholdCallback makes the household active. Default device and group are reset if they are outside of it.
+func (b *bot) handleHouseholdCallback(s *session, householdId string) {
+	iotInfo, err := b.yaClient.getYandexSmartHomeInfo(s)
+	if err != nil {
+		log.WithError(err).Error("Could not process callback. Error occurred trying to get smart home info")
+		return
+	}
+
+	name := "All households"
+	if householdId != "" {
+		name = ""
+		for _, h := range iotInfo.Households {
+			if h.Id == householdId {
+				name = h.Name
+				break
+			}
+		}
+		if name == "" {
+			b.send(s.chatId, "Selected household is not currently available. Please, try again later.")
+			return
+		}
+	}
+
+	ns := NewSessionWithHousehold(s, householdId)
+
+	text := fmt.Sprintf("`%s` is selected. Use /%s to see its stations.", name, ListDevicesCmd)
+	if householdId != "" {
+		stations, _ := b.yaClient.getYandexStations(ns)
+		stations = b.filterActiveHousehold(ns, stations)
+
+		if ns.defaultDevice != nil && deviceHouseholdId(iotInfo, ns.defaultDevice) != householdId {
+			ns = NewSessionWithDevice(ns, nil)
+			text += "\nDefault device is outside of the household, so it has been reset."
+		}
+		if ns.defaultGroupId != "" && len(broadcastTargets(stations, iotInfo, BroadcastGroup, ns.defaultGroupId)) == 0 {
+			ns = NewSessionWithGroup(ns, "")
+			text += "\nDefault group is outside of the household, so it has been reset."
+		}
+	}
+	b.sessionProvider.SaveOrUpdate(ns)
+
+	b.send(s.chatId, text)
+}
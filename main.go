@@ -56,14 +56,85 @@ func setupServer() {
 	http.Handle("/health", healthcheck.Handler(
 		healthcheck.WithTimeout(5*time.Second),
 	))
-	http.Handle("/", http.FileServer(http.Dir('.')))
+	http.HandleFunc("/", serveStaticFile)
 
 	addr := fmt.Sprintf("%s:%s", os.Getenv(HostEnv), os.Getenv(PortEnv))
 	err := http.ListenAndServe(addr, nil)
 	log.Fatalln(err)
 }
 
+// staticFiles are the only files served from the working directory, which may also hold the sessions database
+var staticFiles = map[string]string{
+	"/":           "index.html",
+	"/index.html": "index.html",
+	"/logo.svg":   "logo.svg",
+}
+
+func serveStaticFile(w http.ResponseWriter, r *http.Request) {
+	name, ok := staticFiles[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	http.ServeFile(w, r, name)
+}
+
 func runBot() {
-	b := NewBot(os.Getenv(TelegramBotToken), os.Getenv(YandexClientId))
+	cfg := newBotConfig()
+	sp, bl := newSessionProvider()
+	b := NewBot(cfg, sp, bl)
 	b.Run()
 }
+
+// newSessionProvider returns the session provider and the ban list stored next to the sessions.
+func newSessionProvider() (SessionProvider, BanList) {
+	var sp SessionProvider
+	var bl BanList
+
+	path := os.Getenv(SessionsDbPathEnv)
+	if path == "" {
+		log.Info("Using in-memory session storage. Sessions will be lost on restart")
+		sp = NewInMemorySessionProvider()
+		bl = NewInMemoryBanList()
+	} else {
+		bsp, err := NewBoltSessionProvider(path)
+		if err != nil {
+			log.WithError(err).Fatalf("Could not open sessions database `%s`", path)
+		}
+		log.Infof("Using persistent session storage `%s`", path)
+		sp = bsp
+
+		bl, err = NewBoltBanList(bsp)
+		if err != nil {
+			log.WithError(err).Fatalf("Could not open ban list in database `%s`", path)
+		}
+	}
+
+	key := os.Getenv(SessionsEncryptionKeyEnv)
+	if key == "" {
+		// Sessions stored unencrypted on previous runs are fine, only sealed tokens cannot be read without the key
+		if hasSealedTokens(sp) {
+			log.Fatalf("Sessions database contains encrypted OAuth tokens, but %s is not set. Refusing to start", SessionsEncryptionKeyEnv)
+		}
+
+		log.Warnf("%s is not set. OAuth tokens will be stored unencrypted", SessionsEncryptionKeyEnv)
+		return sp, bl
+	}
+
+	keys, err := NewKeyring(key, os.Getenv(SessionsEncryptionOldKeysEnv))
+	if err != nil {
+		log.WithError(err).Fatal("Could not initialize session encryption")
+	}
+
+	esp := NewEncryptedSessionProvider(sp, keys)
+	n, err := esp.Reseal()
+	if err != nil {
+		log.WithError(err).Fatalf("Could not decrypt stored sessions. Please, add the key they are encrypted with to %s. Refusing to start", SessionsEncryptionOldKeysEnv)
+	}
+	if n > 0 {
+		log.Infof("Re-encrypted %d sessions with the current key", n)
+	}
+
+	return esp, bl
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// The logger is initialized in main, which tests don't run
+	log = logrus.New()
+	log.SetOutput(io.Discard)
+
+	os.Exit(m.Run())
+}
+
+func TestServeStaticFile(t *testing.T) {
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/", http.StatusOK},
+		{"/logo.svg", http.StatusOK},
+		{"/sessions.db", http.StatusNotFound},
+		{"/go.mod", http.StatusNotFound},
+		{"/../main.go", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			serveStaticFile(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if rec.Code != tt.want {
+				t.Errorf("GET %s = %d, want %d", tt.path, rec.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// MediaProvider recognizes links of a single media source
+// and converts them to the form the station player accepts.
+type MediaProvider interface {
+	// Name is the human-readable name of the media source
+	Name() string
+	// Match reports whether the link belongs to the media source
+	Match(u *url.URL) bool
+	// Normalize returns the canonical link of the media. Returns botError if the link is not playable
+	Normalize(u *url.URL) (string, error)
+	// PlayerId is the id of the station player capable of playing the media
+	PlayerId() string
+	// ProviderItemId returns the id of the media passed to the station player
+	ProviderItemId(normalizedUrl string) string
+}
+
+// stationCommandProvider is implemented by providers whose media
+// is started by a station command rather than passed to the video player.
+type stationCommandProvider interface {
+	StationCommand(normalizedUrl string) *stationCommand
+}
+
+type media struct {
+	url            string
+	playerId       string
+	providerItemId string
+	provider       MediaProvider
+	// command is set if the media is started by a station command
+	command *stationCommand
+}
+
+type mediaRegistry struct {
+	providers []MediaProvider
+}
+
+func NewMediaRegistry(providers ...MediaProvider) *mediaRegistry {
+	r := &mediaRegistry{}
+	for _, p := range providers {
+		r.Register(p)
+	}
+
+	return r
+}
+
+func (r *mediaRegistry) Register(p MediaProvider) {
+	r.providers = append(r.providers, p)
+}
+
+// Resolve finds the provider of the link and prepares the media to be played.
+func (r *mediaRegistry) Resolve(rawUrl string) (*media, error) {
+	if !strings.Contains(rawUrl, "://") {
+		rawUrl = "https://" + rawUrl
+	}
+
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, NewBotError("URL link is not valid. Please, send me a valid one.")
+	}
+	u.Host = strings.ToLower(u.Host)
+
+	for _, p := range r.providers {
+		if !p.Match(u) {
+			continue
+		}
+
+		normalized, err := p.Normalize(u)
+		if err != nil {
+			return nil, err
+		}
+
+		m := &media{normalized, p.PlayerId(), p.ProviderItemId(normalized), p, nil}
+		if cp, ok := p.(stationCommandProvider); ok {
+			m.command = cp.StationCommand(normalized)
+		}
+
+		return m, nil
+	}
+
+	return nil, NewBotError(fmt.Sprintf("Sorry, but I support only %s at the moment :(", r.providerNames()))
+}
+
+func (r *mediaRegistry) providerNames() string {
+	names := make([]string, 0, len(r.providers))
+	for _, p := range r.providers {
+		names = append(names, p.Name())
+	}
+
+	if len(names) < 2 {
+		return strings.Join(names, "")
+	}
+
+	return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
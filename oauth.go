@@ -0,0 +1,192 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	tbot "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	OAuthCallbackPath = "/oauth/callback"
+
+	oauthStateTTL = 15 * time.Minute
+)
+
+// oauthStateSigner binds an authorization request to the session it was issued for.
+// State format is `<chat id>.<user id>.<expires at>.<nonce>.<signature>`
+type oauthStateSigner struct {
+	key []byte
+}
+
+// newOAuthStateSigner derives the signing key from the telegram bot token,
+// so no extra secret is required and states are invalidated once the token is revoked.
+func newOAuthStateSigner(botToken string) *oauthStateSigner {
+	sum := sha256.Sum256([]byte("telice-oauth-state:" + botToken))
+	return &oauthStateSigner{sum[:]}
+}
+
+func (s *oauthStateSigner) sign(key sessionKey) (string, error) {
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	payload := fmt.Sprintf("%d.%d.%d.%s", key.chatId, key.userId, time.Now().Add(oauthStateTTL).Unix(), base64.RawURLEncoding.EncodeToString(nonce))
+
+	return payload + "." + s.signature(payload), nil
+}
+
+func (s *oauthStateSigner) verify(state string) (sessionKey, error) {
+	i := strings.LastIndex(state, ".")
+	if i < 0 {
+		return sessionKey{}, errors.New("malformed state")
+	}
+	payload, sig := state[:i], state[i+1:]
+
+	if !hmac.Equal([]byte(sig), []byte(s.signature(payload))) {
+		return sessionKey{}, errors.New("invalid state signature")
+	}
+
+	parts := strings.Split(payload, ".")
+	if len(parts) != 4 {
+		return sessionKey{}, errors.New("malformed state")
+	}
+
+	chatId, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return sessionKey{}, err
+	}
+	userId, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return sessionKey{}, err
+	}
+	expiresAt, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return sessionKey{}, err
+	}
+	if time.Now().Unix() > expiresAt {
+		return sessionKey{}, errors.New("state has expired")
+	}
+
+	return sessionKey{chatId, userId}, nil
+}
+
+func (s *oauthStateSigner) signature(payload string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(payload))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// handleOAuthCallback completes the authorization code flow started by /start.
+func (b *bot) handleOAuthCallback(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	key, err := b.stateSigner.verify(q.Get("state"))
+	if err != nil {
+		log.WithError(err).Warnf("Rejected OAuth callback from %s", r.RemoteAddr)
+		http.Error(w, "Authorization link is invalid or has expired. Please, request a new one with /start.", http.StatusBadRequest)
+		return
+	}
+
+	chatId := key.chatId
+
+	if e := q.Get("error"); e != "" {
+		log.Warnf("OAuth authorization for chat %d has failed: %s", chatId, e)
+		b.send(chatId, "Authentication has been cancelled. Please, click /start to try again.")
+		http.Redirect(w, r, b.botUrl(), http.StatusFound)
+		return
+	}
+
+	oauthToken, csrfToken, refreshToken, err := b.yaClient.exchangeAuthorizationCode(q.Get("code"))
+	if err != nil {
+		log.WithError(err).Errorf("Could not exchange authorization code for chat %d", chatId)
+		b.send(chatId, "Could not complete authentication process. Please, click /start to try again.")
+		http.Error(w, "Could not complete authentication process. Please, try again.", http.StatusBadGateway)
+		return
+	}
+
+	b.dispatcher.Enqueue(chatId, func() {
+		b.completeAuthentication(key, oauthToken, csrfToken, refreshToken)
+	})
+
+	http.Redirect(w, r, b.botUrl(), http.StatusFound)
+}
+
+// completeAuthentication creates a new session or updates tokens of the active account.
+// A new account is linked instead if the user has requested it with /accounts.
+func (b *bot) completeAuthentication(key sessionKey, oauthToken *token, csrfToken *token, refreshToken string) {
+	chatId := key.chatId
+	s, ok := b.sessionProvider.TryGet(key)
+	if ok && s.pendingAccountName != "" {
+		name := s.pendingAccountName
+		b.sessionProvider.SaveOrUpdate(NewSessionWithLinkedAccount(s, name, oauthToken, csrfToken, refreshToken))
+
+		b.send(chatId, fmt.Sprintf("Account `%s` has been linked and is active now. Use /%s to switch between accounts.", name, AccountsCmd))
+		return
+	}
+
+	if ok && s.grantedBy != 0 {
+		// The guest has logged in with their own account, so they don't need the shared stations anymore
+		b.leaveGrant(s)
+		s = NewSession(key, oauthToken, csrfToken, refreshToken)
+	} else if ok {
+		s = NewSessionWithTokens(s, oauthToken, csrfToken, refreshToken)
+	} else {
+		s = NewSession(key, oauthToken, csrfToken, refreshToken)
+	}
+	b.sessionProvider.SaveOrUpdate(s)
+
+	b.send(chatId, "Authentication is complete.\nSend me a link and I will share it with Alice. Have fun!")
+}
+
+// loginUrl returns a new authorization url for the session.
+func (b *bot) loginUrl(key sessionKey) (string, error) {
+	var state string
+	if b.yaClient.isCodeFlowEnabled() {
+		var err error
+		state, err = b.stateSigner.sign(key)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return b.yaClient.getOAuthUrl(state), nil
+}
+
+// sendLoginUrl sends the text followed by a new authorization url. In group chats both are sent to the user privately,
+// so other members of the chat cannot authorize the session with their Yandex accounts.
+func (b *bot) sendLoginUrl(key sessionKey, text string) error {
+	loginUrl, err := b.loginUrl(key)
+	if err != nil {
+		return err
+	}
+
+	if !key.isGroup() {
+		b.send(key.chatId, text)
+		b.send(key.chatId, loginUrl)
+		return nil
+	}
+
+	// Bots can only message users who have started a private chat with them
+	if _, err = b.api.Send(tbot.NewMessage(key.userId, text)); err != nil {
+		log.WithError(err).Warnf("Could not send login url to user %d privately", key.userId)
+		return NewBotError(fmt.Sprintf("I can't message you privately. Please, open %s, press Start and try again.", b.botUrl()))
+	}
+	b.send(key.userId, loginUrl)
+	b.send(key.chatId, "I've sent you the login link in a private chat.")
+
+	return nil
+}
+
+func (b *bot) botUrl() string {
+	return fmt.Sprintf("https://t.me/%s", b.api.Self.UserName)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	tbot "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"math/rand"
+	"net/url"
+	"time"
+)
+
+const (
+	PlaylistFromStart = "start"
+	PlaylistShuffle   = "shuffle"
+	PlaylistFirstOnly = "first"
+)
+
+// youTubePlaylistOf returns the parsed link if the media is a YouTube playlist.
+func youTubePlaylistOf(m *media) (*youTubeUrl, bool) {
+	if _, ok := m.provider.(*youTubeProvider); !ok {
+		return nil, false
+	}
+
+	u, err := url.Parse(m.url)
+	if err != nil {
+		return nil, false
+	}
+	yu, err := parseYouTubeUrl(u)
+	if err != nil || yu.playlistId == "" {
+		return nil, false
+	}
+
+	return yu, true
+}
+
+// youTubeVideoOf returns the parsed link if the media is a single YouTube video.
+func youTubeVideoOf(m *media) (*youTubeUrl, bool) {
+	if _, ok := m.provider.(*youTubeProvider); !ok {
+		return nil, false
+	}
+
+	u, err := url.Parse(m.url)
+	if err != nil {
+		return nil, false
+	}
+	yu, err := parseYouTubeUrl(u)
+	if err != nil || yu.videoId == "" || yu.playlistId != "" {
+		return nil, false
+	}
+
+	return yu, true
+}
+
+func (b *bot) askPlaylistMode(s *session, msg *tbot.Message) error {
+	keyboard := tbot.NewInlineKeyboardMarkup(
+		tbot.NewInlineKeyboardRow(tbot.NewInlineKeyboardButtonData("Play from start", fmt.Sprintf("%s:%s", PlaylistModeCallback, PlaylistFromStart))),
+		tbot.NewInlineKeyboardRow(tbot.NewInlineKeyboardButtonData("Shuffle", fmt.Sprintf("%s:%s", PlaylistModeCallback, PlaylistShuffle))),
+		tbot.NewInlineKeyboardRow(tbot.NewInlineKeyboardButtonData("First video only", fmt.Sprintf("%s:%s", PlaylistModeCallback, PlaylistFirstOnly))),
+	)
+
+	replyMsg := tbot.NewMessage(s.chatId, "This is a playlist. How would you like to play it?")
+	replyMsg.ReplyToMessageID = msg.MessageID
+	replyMsg.ReplyMarkup = keyboard
+
+	//goland:noinspection GoUnhandledErrorResult
+	b.api.Send(replyMsg)
+
+	return nil
+}
+
+func (b *bot) handlePlaylistModeCallback(s *session, replyToMessage *tbot.Message, mode string) {
+	m, err := b.resolveMessageMedia(replyToMessage)
+	if err != nil {
+		log.WithError(err).Error("Could not process callback. Error occurred trying to resolve media")
+		return
+	}
+
+	b.handleError(s.chatId, b.shareMedia(s, replyToMessage, m, mode))
+}
+
+func (b *bot) playYouTubePlaylist(s *session, d *device, yu *youTubeUrl, mode string) error {
+	switch mode {
+	case PlaylistFirstOnly:
+		if yu.videoId == "" {
+			videos, err := b.getPlaylistVideos(yu.playlistId)
+			if err != nil {
+				return err
+			}
+			yu = &youTubeUrl{videoId: videos[0].id}
+		}
+
+		m, err := b.mediaRegistry.Resolve((&youTubeUrl{videoId: yu.videoId, start: yu.start}).String())
+		if err != nil {
+			return err
+		}
+
+		return b.play(s, d, m, "")
+	case PlaylistShuffle:
+		videos, err := b.getPlaylistVideos(yu.playlistId)
+		if err != nil {
+			return err
+		}
+		rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+		rnd.Shuffle(len(videos), func(i, j int) {
+			videos[i], videos[j] = videos[j], videos[i]
+		})
+
+		return b.queuePlaylist(s, d, videos)
+	}
+
+	m, err := b.mediaRegistry.Resolve((&youTubeUrl{playlistId: yu.playlistId}).String())
+	if err != nil {
+		return err
+	}
+
+	b.queueTimers.Stop(s.chatId, d.Id)
+	err = b.yaClient.playMedia(s, d, m)
+	if err == nil {
+		b.sendNowPlaying(s, d, m.url)
+		return nil
+	}
+	if _, ok := err.(*botError); !ok || b.youTubeClient == nil {
+		return err
+	}
+
+	log.WithError(err).Infof("Station has refused playlist %s. Falling back to server-side queue", yu.playlistId)
+
+	videos, err := b.getPlaylistVideos(yu.playlistId)
+	if err != nil {
+		return err
+	}
+
+	return b.queuePlaylist(s, d, videos)
+}
+
+func (b *bot) getPlaylistVideos(playlistId string) ([]youTubeVideo, error) {
+	if b.youTubeClient == nil {
+		return nil, NewBotError(fmt.Sprintf("Sorry, but this requires YouTube Data API access. Ask the bot owner to set %s.", YouTubeApiKeyEnv))
+	}
+
+	videos, err := b.youTubeClient.getPlaylistVideos(playlistId)
+	if err != nil {
+		log.WithError(err).Errorf("Could not get videos of playlist %s", playlistId)
+		return nil, NewBotError("Could not get videos of the playlist. Is it public?")
+	}
+	if len(videos) == 0 {
+		return nil, NewBotError("The playlist is empty.")
+	}
+
+	return videos, nil
+}
+
+// queuePlaylist replaces the queue of the device with the videos and plays them one by one.
+func (b *bot) queuePlaylist(s *session, d *device, videos []youTubeVideo) error {
+	items := make([]queueItem, 0, len(videos))
+	for _, v := range videos {
+		items = append(items, queueItem{(&youTubeUrl{videoId: v.id}).String(), v.title, v.duration})
+	}
+
+	s = NewSessionWithQueue(s, d.Id, items)
+	b.saveQueue(s, d.Id, items)
+
+	err := b.playNextQueued(s, d)
+	if err != nil {
+		return err
+	}
+
+	b.send(s.chatId, fmt.Sprintf("Playing %d videos from the playlist on `%s` one by one.", len(items), d.Name))
+
+	return nil
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	tbot "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"sync"
+	"time"
+)
+
+const (
+	// queueAdvanceDelay is added to the media duration before the next queued item is started
+	// to account for the station loading the media.
+	queueAdvanceDelay = 5 * time.Second
+	// queueUnknownDurationHold is how long the queue is considered busy playing media of unknown duration,
+	// e.g. live streams. Queued items are not started automatically after such media, use /next instead.
+	queueUnknownDurationHold = 3 * time.Hour
+)
+
+type queueItem struct {
+	url      string
+	title    string
+	duration time.Duration
+}
+
+func (i queueItem) String() string {
+	if i.title == "" {
+		return i.url
+	}
+
+	return fmt.Sprintf("%s (%s)", i.title, i.url)
+}
+
+// queueTimers tracks the devices playing queued media and starts the next queued item once the current one is over.
+// Queued items themselves are stored in the session, see session.queues.
+type queueTimers struct {
+	mu     sync.Mutex
+	timers map[string]*time.Timer
+}
+
+func newQueueTimers() *queueTimers {
+	return &queueTimers{timers: make(map[string]*time.Timer)}
+}
+
+func queueTimerKey(chatId int64, deviceId string) string {
+	return fmt.Sprintf("%d_%s", chatId, deviceId)
+}
+
+// Schedule calls next once d has passed unless it is stopped or rescheduled before that.
+func (q *queueTimers) Schedule(chatId int64, deviceId string, d time.Duration, next func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	key := queueTimerKey(chatId, deviceId)
+	if t, ok := q.timers[key]; ok {
+		t.Stop()
+	}
+	q.timers[key] = time.AfterFunc(d, next)
+}
+
+// Stop cancels automatic playback of the next queued item on the device.
+func (q *queueTimers) Stop(chatId int64, deviceId string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	key := queueTimerKey(chatId, deviceId)
+	if t, ok := q.timers[key]; ok {
+		t.Stop()
+	}
+	delete(q.timers, key)
+}
+
+// Active reports whether the device is playing queued media.
+func (q *queueTimers) Active(chatId int64, deviceId string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	_, ok := q.timers[queueTimerKey(chatId, deviceId)]
+	return ok
+}
+
+// newQueueItem creates a queue item for the media. Duration is only known for YouTube videos
+// when YouTube Data API is configured.
+func (b *bot) newQueueItem(m *media) queueItem {
+	item := queueItem{url: m.url}
+
+	yu, ok := youTubeVideoOf(m)
+	if !ok || b.youTubeClient == nil {
+		return item
+	}
+
+	videos := []youTubeVideo{{id: yu.videoId}}
+	if err := b.youTubeClient.fillDurations(videos); err != nil {
+		log.WithError(err).Errorf("Could not get duration of video %s", yu.videoId)
+		return item
+	}
+	// Media starting past its end is of unknown duration, so the queue is not advanced too early
+	if d := videos[0].duration - time.Duration(yu.start)*time.Second; d > 0 {
+		item.duration = d
+	}
+
+	return item
+}
+
+// addToQueue appends the media to the queue of the device. Playback starts right away if the device queue is idle.
+func (b *bot) addToQueue(s *session, d *device, m *media) error {
+	items := append(s.queue(d.Id), b.newQueueItem(m))
+	s = NewSessionWithQueue(s, d.Id, items)
+	b.saveQueue(s, d.Id, items)
+
+	if len(items) == 1 && !b.queueTimers.Active(s.chatId, d.Id) {
+		return b.playNextQueued(s, d)
+	}
+
+	b.send(s.chatId, fmt.Sprintf("Added to the queue of `%s`. Position: %d.", d.Name, len(items)))
+
+	return nil
+}
+
+// playNextQueued starts the next queued item on the device and schedules the one after it.
+func (b *bot) playNextQueued(s *session, d *device) error {
+	items := s.queue(d.Id)
+	if len(items) == 0 {
+		b.queueTimers.Stop(s.chatId, d.Id)
+		return nil
+	}
+
+	item := items[0]
+	m, err := b.mediaRegistry.Resolve(item.url)
+	if err == nil {
+		err = b.yaClient.playMedia(s, d, m)
+	}
+	if err != nil {
+		// The item stays queued, so it can be played with /next once the station is available again
+		b.queueTimers.Stop(s.chatId, d.Id)
+		return err
+	}
+
+	s = NewSessionWithQueue(s, d.Id, items[1:])
+	b.saveQueue(s, d.Id, items[1:])
+
+	b.sendNowPlaying(s, d, item.String())
+
+	key, chatId, deviceId := s.key(), s.chatId, d.Id
+	if item.duration > 0 {
+		b.queueTimers.Schedule(chatId, deviceId, item.duration+queueAdvanceDelay, func() {
+			b.dispatcher.Enqueue(chatId, func() {
+				b.advanceQueue(key, deviceId)
+			})
+		})
+	} else {
+		b.queueTimers.Schedule(chatId, deviceId, queueUnknownDurationHold, func() {
+			b.queueTimers.Stop(chatId, deviceId)
+		})
+	}
+
+	return nil
+}
+
+func (b *bot) advanceQueue(key sessionKey, deviceId string) {
+	chatId := key.chatId
+	s, ok, err := b.loadSession(key)
+	if !ok || err != nil {
+		b.queueTimers.Stop(chatId, deviceId)
+		return
+	}
+
+	s, d, err := b.prepareDevice(s, deviceId)
+	if err != nil {
+		log.WithError(err).Errorf("Could not advance queue of device %s", deviceId)
+		b.queueTimers.Stop(chatId, deviceId)
+		return
+	}
+
+	b.handleError(chatId, b.playNextQueued(s, d))
+}
+
+// prepareDevice finds the station and refreshes session tokens before anything is sent to it.
+// If the station belongs to another linked account, the returned session uses that account to reach the station
+// and must not be stored, since the active account stays the same. See saveQueue.
+// The given session is returned along with an error.
+func (b *bot) prepareDevice(s *session, deviceId string) (*session, *device, error) {
+	d, err := b.findStation(s, deviceId)
+	if err != nil {
+		return s, nil, err
+	}
+
+	for i := 0; d == nil && i < len(s.accounts); i++ {
+		as, _ := NewSessionWithActiveAccount(s, s.accounts[i].name)
+		if d, _ = b.findStation(as, deviceId); d != nil {
+			s = as
+		}
+	}
+	if d == nil {
+		return s, nil, NewBotError("Selected device is not currently available. Please, try again later.")
+	}
+
+	err = b.yaClient.refreshTokens(s)
+	if err != nil {
+		return s, nil, err
+	}
+
+	return s, d, nil
+}
+
+// saveQueue replaces the queue of the device in the stored session. The given session may be using
+// another linked account to reach the device, see prepareDevice, so it is not stored as is.
+func (b *bot) saveQueue(s *session, deviceId string, items []queueItem) {
+	if stored, ok := b.sessionProvider.TryGet(s.key()); ok {
+		b.sessionProvider.SaveOrUpdate(NewSessionWithQueue(stored, deviceId, items))
+	}
+}
+
+// findStation returns the station of the active account with the id or nil if there is no such station or the guest may not use it.
+func (b *bot) findStation(s *session, deviceId string) (*device, error) {
+	devices, err := b.yaClient.getYandexStations(s)
+	if err != nil {
+		return nil, err
+	}
+	devices = filterGrantedDevices(s, devices)
+
+	for _, d := range devices {
+		if d.Id == deviceId {
+			return &d, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (b *bot) handleQueueCommand(s *session) error {
+	devices, err := b.getYandexStations(s)
+	if err != nil {
+		return err
+	}
+
+	buf := bytes.Buffer{}
+	for _, d := range devices {
+		items := s.queue(d.Id)
+		if len(items) == 0 {
+			continue
+		}
+
+		buf.WriteString(fmt.Sprintf("Queue of `%s`:\n", d.Name))
+		for i, item := range items {
+			buf.WriteString(fmt.Sprintf("%d. %s\n", i+1, item))
+		}
+		buf.WriteString("\n")
+	}
+
+	if buf.Len() == 0 {
+		b.send(s.chatId, "All queues are empty. Select \"Add to queue\" when sharing a link to line it up.")
+		return nil
+	}
+
+	buf.WriteString(fmt.Sprintf("Use /%s to skip to the next item or /%s to empty the queue.", NextCmd, ClearQueueCmd))
+	b.send(s.chatId, buf.String())
+
+	return nil
+}
+
+func (b *bot) handleNextCommand(s *session) error {
+	return b.withQueueDevice(s, NextCallback, "Please, select the station to play the next queued item on.", b.handleNextCommandCallback)
+}
+
+func (b *bot) handleClearQueueCommand(s *session) error {
+	return b.withQueueDevice(s, ClearQueueCallback, "Please, select the station whose queue you want to clear.", b.handleClearQueueCommandCallback)
+}
+
+// withQueueDevice calls handle for the default device or the only device with a queue.
+// Otherwise, it asks the user to select one of the devices with a queue.
+func (b *bot) withQueueDevice(s *session, callback string, prompt string, handle func(s *session, deviceId string)) error {
+	if s.defaultDevice != nil {
+		handle(s, s.defaultDevice.Id)
+		return nil
+	}
+
+	devices, err := b.getYandexStations(s)
+	if err != nil {
+		return err
+	}
+
+	queued := make([]device, 0)
+	for _, d := range devices {
+		if len(s.queue(d.Id)) > 0 || b.queueTimers.Active(s.chatId, d.Id) {
+			queued = append(queued, d)
+		}
+	}
+
+	if len(queued) == 0 {
+		return NewBotError("All queues are empty.")
+	}
+	if len(queued) == 1 {
+		handle(s, queued[0].Id)
+		return nil
+	}
+
+	rows := make([][]tbot.InlineKeyboardButton, 0)
+	for _, d := range queued {
+		data := fmt.Sprintf("%s:%s", callback, d.Id)
+		rows = append(rows, tbot.NewInlineKeyboardRow(tbot.NewInlineKeyboardButtonData(d.Name, data)))
+	}
+
+	msg := tbot.NewMessage(s.chatId, prompt)
+	msg.ReplyMarkup = tbot.NewInlineKeyboardMarkup(rows...)
+
+	//goland:noinspection GoUnhandledErrorResult
+	b.api.Send(msg)
+
+	return nil
+}
+
+func (b *bot) handleNextCommandCallback(s *session, deviceId string) {
+	if len(s.queue(deviceId)) == 0 {
+		b.queueTimers.Stop(s.chatId, deviceId)
+		b.send(s.chatId, "The queue is empty.")
+		return
+	}
+
+	s, d, err := b.prepareDevice(s, deviceId)
+	if err != nil {
+		b.handleError(s.chatId, err)
+		return
+	}
+
+	b.handleError(s.chatId, b.playNextQueued(s, d))
+}
+
+func (b *bot) handleClearQueueCommandCallback(s *session, deviceId string) {
+	b.queueTimers.Stop(s.chatId, deviceId)
+	b.sessionProvider.SaveOrUpdate(NewSessionWithQueue(s, deviceId, nil))
+
+	b.send(s.chatId, "The queue has been cleared.")
+}
+
+func (b *bot) handleAddToQueueCallback(s *session, replyToMessage *tbot.Message, deviceId string) {
+	m, err := b.resolveMessageMedia(replyToMessage)
+	if err != nil {
+		log.WithError(err).Error("Could not process callback. Error occurred trying to resolve media")
+		return
+	}
+
+	s, d, err := b.prepareDevice(s, deviceId)
+	if err != nil {
+		b.handleError(s.chatId, err)
+		return
+	}
+
+	b.handleError(s.chatId, b.addToQueue(s, d, m))
+}
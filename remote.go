@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	tbot "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxStationVolume = 10
+	// maxSeekPositionLength keeps the position short enough to fit into callback data along with the device id
+	maxSeekPositionLength = 8
+)
+
+// seekPositionPattern matches `1:02:03` and `2:03` positions
+var seekPositionPattern = regexp.MustCompile(`^(?:(\d+):)?(\d+):(\d{2})$`)
+
+// newRemoteCommand converts the remote control action and its argument to the station command.
+// Action names match the bot commands, e.g. PauseCmd.
+func newRemoteCommand(action string, arg string) (*stationCommand, error) {
+	switch action {
+	// Stations have no separate pause command. Stopped media is resumed from the same position
+	case PauseCmd, StopCmd:
+		return &stationCommand{Command: "stop"}, nil
+	case ResumeCmd:
+		return &stationCommand{Command: "play"}, nil
+	case VolumeCmd:
+		level, err := strconv.Atoi(arg)
+		if err != nil || level < 0 || level > maxStationVolume {
+			return nil, NewBotError(fmt.Sprintf("Please, specify volume from 0 to %d, e.g. /%s 5", maxStationVolume, VolumeCmd))
+		}
+
+		volume := float64(level) / maxStationVolume
+		return &stationCommand{Command: "setVolume", Volume: &volume}, nil
+	case SeekCmd:
+		position, ok := parseSeekPosition(arg)
+		if !ok {
+			return nil, NewBotError(fmt.Sprintf("Please, specify position to seek to, e.g. /%s 1:30 or /%s 90s", SeekCmd, SeekCmd))
+		}
+
+		return &stationCommand{Command: "rewind", Position: &position}, nil
+	}
+
+	return nil, NewBotError("Unknown command.")
+}
+
+// parseSeekPosition returns the position in seconds from `1:02:03`, `2:03`, `1h2m3s` or `123` values.
+func parseSeekPosition(v string) (int, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" || len(v) > maxSeekPositionLength {
+		return 0, false
+	}
+
+	if m := seekPositionPattern.FindStringSubmatch(v); m != nil {
+		hours, _ := strconv.Atoi(m[1])
+		minutes, _ := strconv.Atoi(m[2])
+		seconds, _ := strconv.Atoi(m[3])
+		return hours*3600 + minutes*60 + seconds, true
+	}
+
+	return parseTimestamp(v)
+}
+
+// remoteControlKeyboard is attached to "now playing" messages.
+func remoteControlKeyboard(deviceId string) tbot.InlineKeyboardMarkup {
+	button := func(text string, action string) tbot.InlineKeyboardButton {
+		return tbot.NewInlineKeyboardButtonData(text, fmt.Sprintf("%s:%s:%s", RemoteControlCallback, deviceId, action))
+	}
+
+	return tbot.NewInlineKeyboardMarkup(
+		tbot.NewInlineKeyboardRow(
+			button("⏸ Pause", PauseCmd),
+			button("▶️ Resume", ResumeCmd),
+			button("⏹ Stop", StopCmd),
+		),
+		tbot.NewInlineKeyboardRow(
+			button("🔈 2", VolumeCmd+":2"),
+			button("🔉 5", VolumeCmd+":5"),
+			button("🔊 8", VolumeCmd+":8"),
+		),
+	)
+}
+
+func (b *bot) sendNowPlaying(s *session, d *device, what string) {
+	msg := tbot.NewMessage(s.chatId, fmt.Sprintf("Now playing %s on `%s`.", what, d.Name))
+	msg.ReplyMarkup = remoteControlKeyboard(d.Id)
+
+	_, err := b.api.Send(msg)
+	if err != nil {
+		log.WithError(err).Errorf("Error occurred while trying to send the message to chat %v", s.chatId)
+	}
+}
+
+// handleRemoteCommand sends the command to the default device or the only one available.
+// Otherwise, it asks the user to select the device.
+func (b *bot) handleRemoteCommand(s *session, action string, arg string) error {
+	// Validate arguments before asking for the device
+	if _, err := newRemoteCommand(action, arg); err != nil {
+		return err
+	}
+
+	if s.defaultDevice != nil {
+		return b.remoteControl(s, s.defaultDevice.Id, action, arg)
+	}
+
+	devices, err := b.getYandexStations(s)
+	if err != nil {
+		return err
+	}
+	if len(devices) == 1 {
+		return b.remoteControl(s, devices[0].Id, action, arg)
+	}
+
+	iotInfo, _ := b.yaClient.getYandexSmartHomeInfo(s)
+
+	strs := b.yandexStationsToString(s, devices, iotInfo.Rooms, iotInfo.Households)
+
+	rows := make([][]tbot.InlineKeyboardButton, 0)
+	for i, str := range strs {
+		data := fmt.Sprintf("%s:%s:%s", RemoteControlCallback, devices[i].Id, action)
+		if arg != "" {
+			data = fmt.Sprintf("%s:%s", data, arg)
+		}
+		rows = append(rows, tbot.NewInlineKeyboardRow(tbot.NewInlineKeyboardButtonData(str, data)))
+	}
+
+	msg := tbot.NewMessage(s.chatId, "Please, select the station you want to control.")
+	msg.ReplyMarkup = tbot.NewInlineKeyboardMarkup(rows...)
+
+	//goland:noinspection GoUnhandledErrorResult
+	b.api.Send(msg)
+
+	return nil
+}
+
+// handleRemoteControlCallback handles `<device id>:<action>[:<argument>]` data.
+func (b *bot) handleRemoteControlCallback(s *session, data string) {
+	deviceId, rest, _ := strings.Cut(data, ":")
+	action, arg, _ := strings.Cut(rest, ":")
+
+	b.handleError(s.chatId, b.remoteControl(s, deviceId, action, arg))
+}
+
+func (b *bot) remoteControl(s *session, deviceId string, action string, arg string) error {
+	c, err := newRemoteCommand(action, arg)
+	if err != nil {
+		return err
+	}
+
+	s, d, err := b.prepareDevice(s, deviceId)
+	if err != nil {
+		return err
+	}
+
+	// Queued media must not start while the station is paused or has been rewound,
+	// since its end is not known anymore. Use /next to continue the queue
+	if action != VolumeCmd {
+		b.queueTimers.Stop(s.chatId, d.Id)
+	}
+
+	err = b.yaClient.sendCommand(s, d, c)
+	if err != nil {
+		return err
+	}
+
+	var text string
+	switch action {
+	case PauseCmd:
+		text = fmt.Sprintf("Paused `%s`.", d.Name)
+	case ResumeCmd:
+		text = fmt.Sprintf("Resumed `%s`.", d.Name)
+	case StopCmd:
+		text = fmt.Sprintf("Stopped `%s`.", d.Name)
+	case VolumeCmd:
+		text = fmt.Sprintf("Volume of `%s` is set to %s.", d.Name, arg)
+	case SeekCmd:
+		text = fmt.Sprintf("Rewound `%s` to %s.", d.Name, arg)
+	}
+	b.send(s.chatId, text)
+
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestParseSeekPosition(t *testing.T) {
+	tests := []struct {
+		value  string
+		want   int
+		wantOk bool
+	}{
+		{"1:30", 90, true},
+		{"1:02:03", 3723, true},
+		{"90", 90, true},
+		{"90s", 90, true},
+		{"1m30s", 90, true},
+		{"1h2m3s", 3723, true},
+		{" 2:00 ", 120, true},
+		{"", 0, false},
+		{"1:3", 0, false},
+		{"later", 0, false},
+		{"123456789", 0, false},
+		{"1h02m03s0", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, ok := parseSeekPosition(tt.value)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("parseSeekPosition() = %d, %t, want %d, %t", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
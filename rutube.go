@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// rutubeVideoIdPattern matches 32 hex chars video id in `/video/<id>`, `/play/embed/<id>`, `/shorts/<id>`
+// and `/video/private/<id>` paths
+var rutubeVideoIdPattern = regexp.MustCompile(`^/(?:video/(?:private/)?|play/embed/|embed/|shorts/)([0-9a-f]{32})/?`)
+
+type rutubeProvider struct{}
+
+func NewRutubeProvider() *rutubeProvider {
+	return &rutubeProvider{}
+}
+
+func (p *rutubeProvider) Name() string {
+	return "Rutube"
+}
+
+func (p *rutubeProvider) Match(u *url.URL) bool {
+	host := strings.TrimPrefix(strings.TrimPrefix(u.Host, "www."), "m.")
+	return host == "rutube.ru"
+}
+
+func (p *rutubeProvider) Normalize(u *url.URL) (string, error) {
+	m := rutubeVideoIdPattern.FindStringSubmatch(strings.ToLower(u.Path))
+	if m == nil {
+		return "", NewBotError("This Rutube link doesn't look like a video. Please, send me a link to the video itself.")
+	}
+
+	normalized := fmt.Sprintf("https://rutube.ru/video/%s/", m[1])
+
+	// Private videos are only accessible with the key
+	if key := u.Query().Get("p"); key != "" {
+		normalized += "?p=" + url.QueryEscape(key)
+	}
+
+	return normalized, nil
+}
+
+func (p *rutubeProvider) PlayerId() string {
+	return "rutube"
+}
+
+func (p *rutubeProvider) ProviderItemId(normalizedUrl string) string {
+	return normalizedUrl
+}
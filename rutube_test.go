@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+const testRutubeId = "0123456789abcdef0123456789abcdef"
+
+func TestRutubeProviderNormalize(t *testing.T) {
+	tests := []struct {
+		name    string
+		link    string
+		want    string
+		wantErr bool
+	}{
+		{"video", "https://rutube.ru/video/" + testRutubeId + "/", "https://rutube.ru/video/" + testRutubeId + "/", false},
+		{"without slash", "https://rutube.ru/video/" + testRutubeId, "https://rutube.ru/video/" + testRutubeId + "/", false},
+		{"tracking parameters", "https://rutube.ru/video/" + testRutubeId + "/?r=wd&utm_source=tg", "https://rutube.ru/video/" + testRutubeId + "/", false},
+		{"mobile", "https://m.rutube.ru/video/" + testRutubeId + "/", "https://rutube.ru/video/" + testRutubeId + "/", false},
+		{"upper case id", "https://rutube.ru/video/0123456789ABCDEF0123456789ABCDEF/", "https://rutube.ru/video/" + testRutubeId + "/", false},
+		{"private", "https://rutube.ru/video/private/" + testRutubeId + "/?p=key-1", "https://rutube.ru/video/" + testRutubeId + "/?p=key-1", false},
+		{"embed", "https://rutube.ru/play/embed/" + testRutubeId, "https://rutube.ru/video/" + testRutubeId + "/", false},
+		{"old embed", "https://rutube.ru/embed/" + testRutubeId, "https://rutube.ru/video/" + testRutubeId + "/", false},
+		{"shorts", "https://rutube.ru/shorts/" + testRutubeId + "/", "https://rutube.ru/video/" + testRutubeId + "/", false},
+		{"channel", "https://rutube.ru/channel/12345/", "", true},
+		{"short id", "https://rutube.ru/video/0123/", "", true},
+		{"home", "https://rutube.ru/", "", true},
+	}
+
+	p := NewRutubeProvider()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.link)
+			if err != nil {
+				t.Fatalf("invalid test link: %v", err)
+			}
+			if !p.Match(u) {
+				t.Fatalf("link %s is not matched", tt.link)
+			}
+
+			got, err := p.Normalize(u)
+			if tt.wantErr {
+				if _, ok := err.(*botError); !ok {
+					t.Errorf("Normalize() = %s, %v, want botError", got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Normalize() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Normalize() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	tbot "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxSayLength is the longest phrase Alice agrees to repeat
+const maxSayLength = 100
+
+// sayRequestPrefix asks Alice to repeat the phrase after it. Stations recognize the request in Russian only.
+const sayRequestPrefix = "Повтори за мной "
+
+func newSayCommand(text string) (*stationCommand, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, NewBotError(fmt.Sprintf("Please, specify the text, e.g. /%s Dinner is ready!", SayCmd))
+	}
+	if utf8.RuneCountInString(text) > maxSayLength {
+		return nil, NewBotError(fmt.Sprintf("The text is too long. Alice can say up to %d characters at once.", maxSayLength))
+	}
+
+	return &stationCommand{Command: "sendText", Text: sayRequestPrefix + text}, nil
+}
+
+// handleSayCommand makes the default station or the only one available say the text.
+// Otherwise, it asks the user to select the station.
+func (b *bot) handleSayCommand(s *session, msg *tbot.Message) error {
+	if _, err := newSayCommand(msg.CommandArguments()); err != nil {
+		return err
+	}
+
+	if s.defaultDevice != nil {
+		return b.say(s, s.defaultDevice.Id, msg.CommandArguments())
+	}
+
+	devices, err := b.getYandexStations(s)
+	if err != nil {
+		return err
+	}
+	if len(devices) == 1 {
+		return b.say(s, devices[0].Id, msg.CommandArguments())
+	}
+
+	b.askStation(s, msg, devices, SayCallback, "Please, select the station that should say the text.")
+
+	return nil
+}
+
+// handleSayCallback takes the text from the /say command message the keyboard replies to,
+// since it may not fit into the callback data.
+func (b *bot) handleSayCallback(s *session, replyToMessage *tbot.Message, deviceId string) {
+	b.handleError(s.chatId, b.say(s, deviceId, replyToMessage.CommandArguments()))
+}
+
+func (b *bot) say(s *session, deviceId string, text string) error {
+	c, err := newSayCommand(text)
+	if err != nil {
+		return err
+	}
+
+	s, d, err := b.prepareDevice(s, deviceId)
+	if err != nil {
+		return err
+	}
+
+	err = b.yaClient.sendCommand(s, d, c)
+	if err != nil {
+		return err
+	}
+
+	b.send(s.chatId, fmt.Sprintf("Alice has said it on `%s`.", d.Name))
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	tbot "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func (b *bot) handleScenariosCommand(s *session) error {
+	iotInfo, err := b.yaClient.getYandexSmartHomeInfo(s)
+	if err != nil {
+		return NewBotError("Could not get list of scenarios. Please, try again.")
+	}
+	if len(iotInfo.Scenarios) == 0 {
+		return NewBotError("I didn't find any scenarios. You can create them in the Yandex Smart Home app.")
+	}
+
+	buf := bytes.Buffer{}
+	rows := make([][]tbot.InlineKeyboardButton, 0)
+	for i, sc := range iotInfo.Scenarios {
+		if !sc.IsActive {
+			buf.WriteString(fmt.Sprintf("%d. %s (disabled)\n", i+1, sc.Name))
+			continue
+		}
+
+		buf.WriteString(fmt.Sprintf("%d. %s\n", i+1, sc.Name))
+
+		data := fmt.Sprintf("%s:%s", ScenarioCallback, sc.Id)
+		rows = append(rows, tbot.NewInlineKeyboardRow(tbot.NewInlineKeyboardButtonData(fmt.Sprintf("▶️ %s", sc.Name), data)))
+	}
+
+	msg := tbot.NewMessage(s.chatId, buf.String())
+	if len(rows) > 0 {
+		msg.ReplyMarkup = tbot.NewInlineKeyboardMarkup(rows...)
+	}
+
+	//goland:noinspection GoUnhandledErrorResult
+	b.api.Send(msg)
+
+	return nil
+}
+
+func (b *bot) handleScenarioCallback(s *session, scenarioId string) {
+	name := scenarioId
+	if iotInfo, err := b.yaClient.getYandexSmartHomeInfo(s); err == nil {
+		for _, sc := range iotInfo.Scenarios {
+			if sc.Id == scenarioId {
+				name = sc.Name
+				break
+			}
+		}
+	}
+
+	resp, err := b.yaClient.runScenario(s, scenarioId)
+	if err != nil {
+		log.WithError(err).Errorf("Could not run scenario %s", scenarioId)
+		b.send(s.chatId, fmt.Sprintf("Could not run scenario `%s`. Please, try again later.", name))
+		return
+	}
+
+	if resp.Status != "ok" {
+		log.Errorf("Scenario %s has been refused. Request: %s. Message: %s", scenarioId, resp.RequestId, resp.Message)
+		b.send(s.chatId, fmt.Sprintf("Yandex has refused to run scenario `%s`: %s", name, resp.Message))
+		return
+	}
+
+	b.send(s.chatId, fmt.Sprintf("Scenario `%s` has been started.", name))
+}
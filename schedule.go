@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	tbot "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	// Time zone database is embedded, so /timezone works in images without one
+	_ "time/tzdata"
+)
+
+const (
+	maxScheduledJobs = 20
+	// scheduledJobGrace is how late a job missed while the bot was offline may still be started
+	scheduledJobGrace = 10 * time.Minute
+
+	scheduledTimeLayout = "Mon, 2 Jan 15:04"
+)
+
+// utcOffsetPattern matches `+3`, `-05:30`, `UTC+3` and `GMT+0300` time zones
+var utcOffsetPattern = regexp.MustCompile(`^(?i:utc|gmt)?([+-])(\d{1,2})(?::?(\d{2}))?$`)
+
+type scheduledJob struct {
+	id       string
+	at       time.Time
+	url      string
+	deviceId string
+}
+
+// jobTimers starts scheduled jobs at their time. Jobs themselves are stored in the session, see session.jobs.
+type jobTimers struct {
+	mu     sync.Mutex
+	timers map[string]*time.Timer
+}
+
+func newJobTimers() *jobTimers {
+	return &jobTimers{timers: make(map[string]*time.Timer)}
+}
+
+// Schedule calls run at the time. It is called right away if the time has passed.
+func (t *jobTimers) Schedule(jobId string, at time.Time, run func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if timer, ok := t.timers[jobId]; ok {
+		timer.Stop()
+	}
+	t.timers[jobId] = time.AfterFunc(time.Until(at), func() {
+		t.Stop(jobId)
+		run()
+	})
+}
+
+func (t *jobTimers) Stop(jobId string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if timer, ok := t.timers[jobId]; ok {
+		timer.Stop()
+	}
+	delete(t.timers, jobId)
+}
+
+func newJobId() (string, error) {
+	buf := make([]byte, 4)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+// parseTimeZone accepts IANA time zone names, e.g. `Europe/Moscow`, and UTC offsets, e.g. `+3` or `UTC-05:30`.
+func parseTimeZone(v string) (*time.Location, error) {
+	if v == "" {
+		return time.UTC, nil
+	}
+
+	if m := utcOffsetPattern.FindStringSubmatch(v); m != nil {
+		hours, _ := strconv.Atoi(m[2])
+		minutes, _ := strconv.Atoi(m[3])
+		if hours > 14 || minutes > 59 {
+			return nil, errors.New("utc offset is out of range")
+		}
+
+		offset := hours*3600 + minutes*60
+		if m[1] == "-" {
+			offset = -offset
+		}
+
+		return time.FixedZone(fmt.Sprintf("UTC%s%02d:%02d", m[1], hours, minutes), offset), nil
+	}
+
+	return time.LoadLocation(v)
+}
+
+// location returns the time zone of the session. UTC is returned if it is not set.
+func (s *session) location() *time.Location {
+	loc, err := parseTimeZone(s.timeZone)
+	if err != nil {
+		return time.UTC
+	}
+
+	return loc
+}
+
+// parseScheduleCommand returns the playback time and the link from `/at 07:30 <url>` and `/in 20m <url>` commands.
+// Times are counted from the moment the message has been sent.
+func parseScheduleCommand(msg *tbot.Message, loc *time.Location) (time.Time, string, error) {
+	fields := strings.Fields(msg.CommandArguments())
+	if len(fields) < 2 {
+		return time.Time{}, "", NewBotError(fmt.Sprintf("Please, specify time and link, e.g. /%s 07:30 <link> or /%s 20m <link>", AtCmd, InCmd))
+	}
+	when, rawUrl := fields[0], fields[1]
+	sentAt := msg.Time().In(loc)
+
+	if msg.Command() == InCmd {
+		d, err := time.ParseDuration(when)
+		if err != nil || d <= 0 {
+			return time.Time{}, "", NewBotError(fmt.Sprintf("Please, specify delay like 20m or 1h30m, e.g. /%s 20m <link>", InCmd))
+		}
+
+		return sentAt.Add(d), rawUrl, nil
+	}
+
+	t, err := time.Parse("15:04", when)
+	if err != nil {
+		return time.Time{}, "", NewBotError(fmt.Sprintf("Please, specify time like 07:30, e.g. /%s 07:30 <link>", AtCmd))
+	}
+
+	at := time.Date(sentAt.Year(), sentAt.Month(), sentAt.Day(), t.Hour(), t.Minute(), 0, 0, loc)
+	if !at.After(sentAt) {
+		at = at.AddDate(0, 0, 1)
+	}
+
+	return at, rawUrl, nil
+}
+
+func (b *bot) handleScheduleCommand(s *session, msg *tbot.Message) error {
+	_, rawUrl, err := parseScheduleCommand(msg, s.location())
+	if err != nil {
+		return err
+	}
+	if _, err = b.mediaRegistry.Resolve(rawUrl); err != nil {
+		return err
+	}
+
+	if s.defaultDevice != nil {
+		return b.scheduleJob(s, msg, s.defaultDevice.Id)
+	}
+
+	devices, err := b.getYandexStations(s)
+	if err != nil {
+		return err
+	}
+	if len(devices) == 1 {
+		return b.scheduleJob(s, msg, devices[0].Id)
+	}
+
+	b.askStation(s, msg, devices, ScheduleCallback, "Please, select the station you want to play media on.")
+
+	return nil
+}
+
+func (b *bot) handleScheduleCallback(s *session, replyToMessage *tbot.Message, deviceId string) {
+	b.handleError(s.chatId, b.scheduleJob(s, replyToMessage, deviceId))
+}
+
+// scheduleJob saves the job described by the schedule command message to the session and arms its timer.
+func (b *bot) scheduleJob(s *session, msg *tbot.Message, deviceId string) error {
+	loc := s.location()
+
+	at, rawUrl, err := parseScheduleCommand(msg, loc)
+	if err != nil {
+		return err
+	}
+	if at.Before(time.Now()) {
+		return NewBotError("This time has already passed. Please, schedule the media again.")
+	}
+
+	m, err := b.mediaRegistry.Resolve(rawUrl)
+	if err != nil {
+		return err
+	}
+
+	if len(s.jobs) >= maxScheduledJobs {
+		return NewBotError(fmt.Sprintf("You can schedule up to %d media at once. Use /%s to cancel some of them.", maxScheduledJobs, ScheduledCmd))
+	}
+
+	id, err := newJobId()
+	if err != nil {
+		return err
+	}
+
+	job := scheduledJob{id, at.UTC(), m.url, deviceId}
+	jobs := append(append([]scheduledJob(nil), s.jobs...), job)
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return jobs[i].at.Before(jobs[j].at)
+	})
+	b.sessionProvider.SaveOrUpdate(NewSessionWithJobs(s, jobs))
+
+	b.armScheduledJob(s.key(), job)
+
+	text := fmt.Sprintf("Scheduled %s on `%s` for %s (%s).", m.url, b.stationName(s, deviceId), at.In(loc).Format(scheduledTimeLayout), loc)
+	if s.timeZone == "" {
+		text += fmt.Sprintf("\nUse /%s to set your time zone.", TimeZoneCmd)
+	}
+	b.send(s.chatId, text)
+
+	return nil
+}
+
+func (b *bot) armScheduledJob(key sessionKey, job scheduledJob) {
+	b.jobTimers.Schedule(job.id, job.at, func() {
+		b.dispatcher.Enqueue(key.chatId, func() {
+			b.runScheduledJob(key, job.id)
+		})
+	})
+}
+
+// restoreScheduledJobs arms timers of the jobs stored in sessions on start.
+// Jobs missed while the bot was offline for longer than scheduledJobGrace are dropped.
+func (b *bot) restoreScheduledJobs() {
+	for _, key := range b.sessionProvider.Keys() {
+		key, chatId := key, key.chatId
+		b.dispatcher.Enqueue(chatId, func() {
+			s, ok := b.sessionProvider.TryGet(key)
+			if !ok || len(s.jobs) == 0 {
+				return
+			}
+
+			jobs := make([]scheduledJob, 0, len(s.jobs))
+			for _, j := range s.jobs {
+				if time.Since(j.at) > scheduledJobGrace {
+					log.Warnf("Dropping scheduled job %s of chat %d missed at %s", j.id, chatId, j.at)
+					b.send(chatId, fmt.Sprintf("I was offline and missed scheduled playback of %s at %s. Sorry!",
+						j.url, j.at.In(s.location()).Format(scheduledTimeLayout)))
+					continue
+				}
+
+				jobs = append(jobs, j)
+				b.armScheduledJob(key, j)
+			}
+
+			if len(jobs) != len(s.jobs) {
+				b.sessionProvider.SaveOrUpdate(NewSessionWithJobs(s, jobs))
+			}
+		})
+	}
+}
+
+func (b *bot) runScheduledJob(key sessionKey, jobId string) {
+	s, ok, err := b.loadSession(key)
+	if !ok || err != nil {
+		return
+	}
+	chatId := key.chatId
+
+	job, remaining, ok := removeJob(s.jobs, jobId)
+	if !ok {
+		return
+	}
+	s = NewSessionWithJobs(s, remaining)
+	b.sessionProvider.SaveOrUpdate(s)
+
+	log.Infof("Running scheduled job %s of chat %d", jobId, chatId)
+
+	m, err := b.mediaRegistry.Resolve(job.url)
+	if err != nil {
+		b.handleError(chatId, err)
+		return
+	}
+
+	s, d, err := b.prepareDevice(s, job.deviceId)
+	if err != nil {
+		b.handleError(chatId, err)
+		return
+	}
+
+	b.handleError(chatId, b.play(s, d, m, ""))
+}
+
+// removeJob returns the job with the id and the rest of the jobs.
+func removeJob(jobs []scheduledJob, jobId string) (scheduledJob, []scheduledJob, bool) {
+	for i, j := range jobs {
+		if j.id == jobId {
+			remaining := append(append([]scheduledJob(nil), jobs[:i]...), jobs[i+1:]...)
+			return j, remaining, true
+		}
+	}
+
+	return scheduledJob{}, nil, false
+}
+
+func (b *bot) handleScheduledCommand(s *session) error {
+	if len(s.jobs) == 0 {
+		b.send(s.chatId, fmt.Sprintf("Nothing is scheduled. Use /%s 07:30 <link> or /%s 20m <link> to schedule media.", AtCmd, InCmd))
+		return nil
+	}
+
+	loc := s.location()
+
+	buf := bytes.Buffer{}
+	rows := make([][]tbot.InlineKeyboardButton, 0)
+	for i, j := range s.jobs {
+		buf.WriteString(fmt.Sprintf("%d. %s - %s on `%s`\n", i+1, j.at.In(loc).Format(scheduledTimeLayout), j.url, b.stationName(s, j.deviceId)))
+
+		data := fmt.Sprintf("%s:%s", CancelScheduledCallback, j.id)
+		rows = append(rows, tbot.NewInlineKeyboardRow(tbot.NewInlineKeyboardButtonData(fmt.Sprintf("Cancel %d", i+1), data)))
+	}
+	buf.WriteString(fmt.Sprintf("\nTimes are given in %s.", loc))
+
+	msg := tbot.NewMessage(s.chatId, buf.String())
+	msg.ReplyMarkup = tbot.NewInlineKeyboardMarkup(rows...)
+
+	//goland:noinspection GoUnhandledErrorResult
+	b.api.Send(msg)
+
+	return nil
+}
+
+func (b *bot) handleCancelScheduledCallback(s *session, jobId string) {
+	job, remaining, ok := removeJob(s.jobs, jobId)
+	if !ok {
+		b.send(s.chatId, "This media has already been played or cancelled.")
+		return
+	}
+
+	b.jobTimers.Stop(jobId)
+	b.sessionProvider.SaveOrUpdate(NewSessionWithJobs(s, remaining))
+
+	b.send(s.chatId, fmt.Sprintf("Scheduled playback of %s has been cancelled.", job.url))
+}
+
+func (b *bot) handleTimeZoneCommand(s *session, args string) error {
+	args = strings.TrimSpace(args)
+	if args == "" {
+		text := fmt.Sprintf("Your time zone is %s. To change it, send me /%s with a time zone name or UTC offset, e.g. /%s Europe/Moscow or /%s +3",
+			s.location(), TimeZoneCmd, TimeZoneCmd, TimeZoneCmd)
+		b.send(s.chatId, text)
+		return nil
+	}
+
+	loc, err := parseTimeZone(args)
+	if err != nil {
+		return NewBotError(fmt.Sprintf("I don't know time zone `%s`. Please, use a name like Europe/Moscow or UTC offset like +3.", args))
+	}
+
+	b.sessionProvider.SaveOrUpdate(NewSessionWithTimeZone(s, args))
+
+	b.send(s.chatId, fmt.Sprintf("Time zone is set to %s. Current time is %s.", loc, time.Now().In(loc).Format("15:04")))
+
+	return nil
+}
+
+// stationName returns the name of the station or its id if the station is not available.
+func (b *bot) stationName(s *session, deviceId string) string {
+	devices, err := b.yaClient.getYandexStations(s)
+	if err == nil {
+		for _, d := range devices {
+			if d.Id == deviceId {
+				return d.Name
+			}
+		}
+	}
+
+	return deviceId
+}
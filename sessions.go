@@ -1,51 +1,392 @@
 package main
 
+import (
+	"sync"
+	"time"
+)
+
+// SessionProvider implementations must be safe for concurrent use
+// since updates from different chats are processed in parallel.
 type SessionProvider interface {
 	SaveOrUpdate(newSession *session)
-	TryGet(chatId int64) (*session, bool)
-	Delete(chatId int64)
+	TryGet(key sessionKey) (*session, bool)
+	Delete(key sessionKey)
+	Count() int
+	Keys() []sessionKey
+	// SharedKey returns the key of the session shared with the group chat, see session.sharedPermissions.
+	// Providers keep an index of shared sessions, so group members are served without scanning all of them.
+	SharedKey(chatId int64) (sessionKey, bool)
+}
+
+// sessionKey identifies the session of the user in the chat. Every member of a group chat has a session of their own.
+// In private chats userId equals chatId.
+type sessionKey struct {
+	chatId int64
+	userId int64
+}
+
+func (k sessionKey) isGroup() bool {
+	return k.chatId != k.userId
 }
 
-type sessions map[int64]*session
+type inMemorySessionProvider struct {
+	mu       sync.RWMutex
+	sessions map[sessionKey]*session
+	// sharedBy holds ids of the users sharing their stations by group chat id
+	sharedBy map[int64]int64
+}
 
 type session struct {
 	chatId        int64
+	userId        int64
 	oauthToken    *token
 	csrfToken     *token
+	refreshToken  string
 	defaultDevice *device
+	// defaultGroupId is the id of the device group media is played on by default. Set only if defaultDevice is nil
+	defaultGroupId string
+	// householdId is the id of the household devices are listed from. Devices of all households are listed if empty
+	householdId string
+	// expiryNotifiedAt is set once the user has been warned that the OAuth token is about to expire
+	expiryNotifiedAt *time.Time
+	// queues holds media queued for playback by device id
+	queues map[string][]queueItem
+	// timeZone is the IANA name or UTC offset scheduled playback times are given in. UTC is used if empty
+	timeZone string
+	// jobs holds media scheduled for playback ordered by time
+	jobs []scheduledJob
+	// accountName is the name of the active Yandex account, see activeAccountName
+	accountName string
+	// accounts holds other linked Yandex accounts. Tokens and device settings above belong to the active one
+	accounts []account
+	// pendingAccountName is the name the account authorized next is linked as. Tokens of the active account are updated if empty
+	pendingAccountName string
+	// spanAccounts makes the device picker list stations of all linked accounts
+	spanAccounts bool
+	// sharedPermissions are granted to members of the group chat who use the stations of the session. Not shared if zero
+	sharedPermissions permission
+	// invites holds one-time links to the stations of the session created with /share
+	invites []invite
+	// grants holds users who have accepted the invites
+	grants []grant
+	// grantedBy is the id of the user whose stations are used instead of a Yandex login of its own, see guestSession
+	grantedBy int64
+	// grantedDevices are the ids of the stations the guest may use. Filled in from the grant on every load and never stored
+	grantedDevices []string
+}
+
+// account holds the tokens and device settings of a linked Yandex account while it is not active.
+type account struct {
+	name             string
+	oauthToken       *token
+	refreshToken     string
+	expiryNotifiedAt *time.Time
+	defaultDevice    *device
+	defaultGroupId   string
+	householdId      string
 }
 
 type token struct {
 	value     string
-	expiresIn *int
+	expiresAt *time.Time
 }
 
+// NewToken creates a token expiring in expiresIn seconds. Token never expires if expiresIn is nil.
 func NewToken(value string, expiresIn *int) *token {
-	return &token{value, expiresIn}
+	var expiresAt *time.Time
+	if expiresIn != nil {
+		t := time.Now().UTC().Add(time.Duration(*expiresIn) * time.Second)
+		expiresAt = &t
+	}
+
+	return &token{value, expiresAt}
 }
 
-func NewSession(chatId int64, oauthToken *token, csrfToken *token) *session {
-	return &session{chatId, oauthToken, csrfToken, nil}
+func NewSession(key sessionKey, oauthToken *token, csrfToken *token, refreshToken string) *session {
+	return &session{chatId: key.chatId, userId: key.userId, oauthToken: oauthToken, csrfToken: csrfToken, refreshToken: refreshToken}
+}
+
+func (s *session) key() sessionKey {
+	return sessionKey{s.chatId, s.userId}
 }
 
 func NewSessionWithDevice(s *session, d *device) *session {
-	return &session{s.chatId, s.oauthToken, s.csrfToken, d}
+	ns := *s
+	ns.defaultDevice = d
+	ns.defaultGroupId = ""
+	return &ns
+}
+
+// NewSessionWithGroup selects the device group as default instead of the default device.
+func NewSessionWithGroup(s *session, groupId string) *session {
+	ns := *s
+	ns.defaultDevice = nil
+	ns.defaultGroupId = groupId
+	return &ns
+}
+
+// NewSessionWithHousehold makes the household active. Default device and group are kept,
+// so callers are responsible for resetting them if they don't belong to the household.
+func NewSessionWithHousehold(s *session, householdId string) *session {
+	ns := *s
+	ns.householdId = householdId
+	return &ns
+}
+
+// NewSessionWithTokens replaces the tokens of the session keeping the rest of its settings.
+func NewSessionWithTokens(s *session, oauthToken *token, csrfToken *token, refreshToken string) *session {
+	ns := *s
+	ns.oauthToken = oauthToken
+	ns.csrfToken = csrfToken
+	ns.refreshToken = refreshToken
+	ns.expiryNotifiedAt = nil
+	return &ns
+}
+
+// NewSessionWithQueue replaces the queue of the device. Empty queue is removed.
+func NewSessionWithQueue(s *session, deviceId string, items []queueItem) *session {
+	ns := *s
+	ns.queues = make(map[string][]queueItem, len(s.queues))
+	for k, v := range s.queues {
+		ns.queues[k] = v
+	}
+
+	if len(items) == 0 {
+		delete(ns.queues, deviceId)
+	} else {
+		ns.queues[deviceId] = items
+	}
+
+	return &ns
+}
+
+func NewSessionWithTimeZone(s *session, timeZone string) *session {
+	ns := *s
+	ns.timeZone = timeZone
+	return &ns
+}
+
+// NewSessionWithJobs replaces scheduled jobs of the session.
+func NewSessionWithJobs(s *session, jobs []scheduledJob) *session {
+	ns := *s
+	ns.jobs = append([]scheduledJob(nil), jobs...)
+	return &ns
+}
+
+// activeAccountName returns the name of the active account. The first linked account is named DefaultAccountName.
+func (s *session) activeAccountName() string {
+	if s.accountName == "" {
+		return DefaultAccountName
+	}
+
+	return s.accountName
+}
+
+// hasAccount reports whether the account with the name is linked to the chat.
+func (s *session) hasAccount(name string) bool {
+	if s.activeAccountName() == name {
+		return true
+	}
+	for _, a := range s.accounts {
+		if a.name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *session) activeAccount() account {
+	return account{
+		name:             s.activeAccountName(),
+		oauthToken:       s.oauthToken,
+		refreshToken:     s.refreshToken,
+		expiryNotifiedAt: s.expiryNotifiedAt,
+		defaultDevice:    s.defaultDevice,
+		defaultGroupId:   s.defaultGroupId,
+		householdId:      s.householdId,
+	}
+}
+
+// withActiveAccount replaces the tokens and device settings of the session with the ones of the account.
+func (s *session) withActiveAccount(a account) {
+	s.accountName = a.name
+	s.oauthToken = a.oauthToken
+	s.csrfToken = nil
+	s.refreshToken = a.refreshToken
+	s.expiryNotifiedAt = a.expiryNotifiedAt
+	s.defaultDevice = a.defaultDevice
+	s.defaultGroupId = a.defaultGroupId
+	s.householdId = a.householdId
+}
+
+// NewSessionWithActiveAccount makes the linked account active. Returns false if there is no account with the name.
+// CSRF token is reset, so it has to be refreshed before the station is used.
+func NewSessionWithActiveAccount(s *session, name string) (*session, bool) {
+	ns := *s
+	if s.activeAccountName() == name {
+		return &ns, true
+	}
+
+	ns.accounts = make([]account, 0, len(s.accounts))
+	var found *account
+	for i, a := range s.accounts {
+		if a.name == name {
+			found = &s.accounts[i]
+			continue
+		}
+		ns.accounts = append(ns.accounts, a)
+	}
+	if found == nil {
+		return nil, false
+	}
+
+	ns.accounts = append(ns.accounts, s.activeAccount())
+	ns.withActiveAccount(*found)
+
+	return &ns, true
+}
+
+// NewSessionWithLinkedAccount links a new account and makes it active. The previously active account is kept.
+func NewSessionWithLinkedAccount(s *session, name string, oauthToken *token, csrfToken *token, refreshToken string) *session {
+	ns := *s
+	ns.accounts = append(append([]account(nil), s.accounts...), s.activeAccount())
+	ns.withActiveAccount(account{name: name, oauthToken: oauthToken, refreshToken: refreshToken})
+	ns.csrfToken = csrfToken
+	ns.pendingAccountName = ""
+	return &ns
+}
+
+// NewSessionWithUpdatedAccount replaces the linked account having the same name. The active account cannot be updated this way.
+func NewSessionWithUpdatedAccount(s *session, a account) *session {
+	ns := *s
+	ns.accounts = append([]account(nil), s.accounts...)
+	for i := range ns.accounts {
+		if ns.accounts[i].name == a.name {
+			ns.accounts[i] = a
+		}
+	}
+	return &ns
+}
+
+// NewSessionWithoutAccount unlinks the account. The active account cannot be unlinked this way.
+func NewSessionWithoutAccount(s *session, name string) *session {
+	ns := *s
+	ns.accounts = make([]account, 0, len(s.accounts))
+	for _, a := range s.accounts {
+		if a.name != name {
+			ns.accounts = append(ns.accounts, a)
+		}
+	}
+	return &ns
+}
+
+func NewSessionWithPendingAccount(s *session, name string) *session {
+	ns := *s
+	ns.pendingAccountName = name
+	return &ns
+}
+
+func NewSessionWithSpanAccounts(s *session, span bool) *session {
+	ns := *s
+	ns.spanAccounts = span
+	return &ns
+}
+
+// NewSessionWithSharedPermissions shares the stations with other members of the group chat. Sharing stops if permissions are zero.
+func NewSessionWithSharedPermissions(s *session, permissions permission) *session {
+	ns := *s
+	ns.sharedPermissions = permissions
+	return &ns
+}
+
+// NewGuestSession creates a session using the stations shared by the owner instead of a Yandex login.
+func NewGuestSession(key sessionKey, ownerId int64) *session {
+	return &session{chatId: key.chatId, userId: key.userId, grantedBy: ownerId}
+}
+
+func NewSessionWithInvites(s *session, invites []invite) *session {
+	ns := *s
+	ns.invites = append([]invite(nil), invites...)
+	return &ns
+}
+
+func NewSessionWithGrants(s *session, grants []grant) *session {
+	ns := *s
+	ns.grants = append([]grant(nil), grants...)
+	return &ns
+}
+
+// queue returns a copy of the device queue.
+func (s *session) queue(deviceId string) []queueItem {
+	return append([]queueItem(nil), s.queues[deviceId]...)
 }
 
 //goland:noinspection GoExportedFuncWithUnexportedType
-func NewInMemorySessionProvider() sessions {
-	return make(sessions)
+func NewInMemorySessionProvider() *inMemorySessionProvider {
+	return &inMemorySessionProvider{sessions: make(map[sessionKey]*session), sharedBy: make(map[int64]int64)}
+}
+
+func (p *inMemorySessionProvider) SaveOrUpdate(newSession *session) {
+	s := *newSession
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sessions[newSession.key()] = &s
+
+	if s.sharedPermissions != 0 {
+		p.sharedBy[s.chatId] = s.userId
+	} else if p.sharedBy[s.chatId] == s.userId {
+		delete(p.sharedBy, s.chatId)
+	}
+}
+
+// TryGet returns a copy of the stored session, so callers are free to modify it.
+func (p *inMemorySessionProvider) TryGet(key sessionKey) (*session, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	s, ok := p.sessions[key]
+	if !ok {
+		return nil, false
+	}
+	c := *s
+
+	return &c, true
 }
 
-func (ss sessions) SaveOrUpdate(newSession *session) {
-	ss[newSession.chatId] = newSession
+func (p *inMemorySessionProvider) Delete(key sessionKey) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.sessions, key)
+
+	if p.sharedBy[key.chatId] == key.userId {
+		delete(p.sharedBy, key.chatId)
+	}
 }
 
-func (ss sessions) TryGet(chatId int64) (*session, bool) {
-	s, ok := ss[chatId]
-	return s, ok
+func (p *inMemorySessionProvider) Count() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.sessions)
 }
 
-func (ss sessions) Delete(chatId int64) {
-	delete(ss, chatId)
+func (p *inMemorySessionProvider) Keys() []sessionKey {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	keys := make([]sessionKey, 0, len(p.sessions))
+	for k := range p.sessions {
+		keys = append(keys, k)
+	}
+
+	return keys
+}
+
+func (p *inMemorySessionProvider) SharedKey(chatId int64) (sessionKey, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	userId, ok := p.sharedBy[chatId]
+	return sessionKey{chatId, userId}, ok
 }
@@ -0,0 +1,607 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sessionsSchemaVersion is the version of the persisted session record.
+// Bump it and append a migration to sessionMigrations whenever sessionRecord changes.
+const sessionsSchemaVersion = 9
+
+var (
+	sessionsBucket = []byte("sessions")
+	metaBucket     = []byte("meta")
+	// sharedBucket indexes sessions shared with group chats. It holds user ids by chat id
+	sharedBucket = []byte("shared_sessions")
+
+	schemaVersionKey = []byte("schema_version")
+)
+
+// sessionMigrations upgrade raw records one version at a time.
+// sessionMigrations[i] migrates a record from version i+1 to version i+2.
+var sessionMigrations = []func(rec map[string]interface{}) error{
+	migrateSessionRecordV1,
+	migrateSessionRecordV2,
+	migrateSessionRecordV3,
+	migrateSessionRecordV4,
+	migrateSessionRecordV5,
+	migrateSessionRecordV6,
+	migrateSessionRecordV7,
+	migrateSessionRecordV8,
+}
+
+// errObsoleteSessionRecord is returned by migrations for records that cannot be upgraded and are removed instead.
+var errObsoleteSessionRecord = errors.New("obsolete session record")
+
+type sessionRecord struct {
+	Version           int                          `json:"version"`
+	ChatId            int64                        `json:"chat_id"`
+	UserId            int64                        `json:"user_id"`
+	OAuthToken        *tokenRecord                 `json:"oauth_token"`
+	RefreshToken      string                       `json:"refresh_token,omitempty"`
+	DefaultDevice     *device                      `json:"default_device,omitempty"`
+	DefaultGroupId    string                       `json:"default_group_id,omitempty"`
+	HouseholdId       string                       `json:"household_id,omitempty"`
+	ExpiryNotifiedAt  *time.Time                   `json:"expiry_notified_at,omitempty"`
+	Queues            map[string][]queueItemRecord `json:"queues,omitempty"`
+	TimeZone          string                       `json:"time_zone,omitempty"`
+	Jobs              []scheduledJobRecord         `json:"jobs,omitempty"`
+	AccountName       string                       `json:"account_name,omitempty"`
+	Accounts          []accountRecord              `json:"accounts,omitempty"`
+	PendingAccount    string                       `json:"pending_account,omitempty"`
+	SpanAccounts      bool                         `json:"span_accounts,omitempty"`
+	SharedPermissions permission                   `json:"shared_permissions,omitempty"`
+	Invites           []inviteRecord               `json:"invites,omitempty"`
+	Grants            []grantRecord                `json:"grants,omitempty"`
+	GrantedBy         int64                        `json:"granted_by,omitempty"`
+	CreatedAt         time.Time                    `json:"created_at"`
+}
+
+type queueItemRecord struct {
+	Url      string        `json:"url"`
+	Title    string        `json:"title,omitempty"`
+	Duration time.Duration `json:"duration,omitempty"`
+}
+
+type accountRecord struct {
+	Name             string       `json:"name"`
+	OAuthToken       *tokenRecord `json:"oauth_token"`
+	RefreshToken     string       `json:"refresh_token,omitempty"`
+	ExpiryNotifiedAt *time.Time   `json:"expiry_notified_at,omitempty"`
+	DefaultDevice    *device      `json:"default_device,omitempty"`
+	DefaultGroupId   string       `json:"default_group_id,omitempty"`
+	HouseholdId      string       `json:"household_id,omitempty"`
+}
+
+type scheduledJobRecord struct {
+	Id       string    `json:"id"`
+	At       time.Time `json:"at"`
+	Url      string    `json:"url"`
+	DeviceId string    `json:"device_id"`
+}
+
+type inviteRecord struct {
+	Token     string    `json:"token"`
+	Account   string    `json:"account"`
+	DeviceIds []string  `json:"device_ids"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type grantRecord struct {
+	UserId    int64    `json:"user_id"`
+	Name      string   `json:"name"`
+	Account   string   `json:"account"`
+	DeviceIds []string `json:"device_ids"`
+}
+
+type tokenRecord struct {
+	Value     string     `json:"value"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type boltSessionProvider struct {
+	db *bolt.DB
+}
+
+func NewBoltSessionProvider(path string) (*boltSessionProvider, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	p := &boltSessionProvider{db}
+	if err = p.migrate(); err == nil {
+		err = p.indexSharedSessions()
+	}
+	if err != nil {
+		//goland:noinspection GoUnhandledErrorResult
+		db.Close()
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (p *boltSessionProvider) migrate() error {
+	return p.db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(metaBucket)
+		if err != nil {
+			return err
+		}
+		bucket, err := tx.CreateBucketIfNotExists(sessionsBucket)
+		if err != nil {
+			return err
+		}
+
+		version := sessionsSchemaVersion
+		if v := meta.Get(schemaVersionKey); v != nil {
+			version, err = strconv.Atoi(string(v))
+			if err != nil {
+				return err
+			}
+		}
+		if version > sessionsSchemaVersion {
+			return fmt.Errorf("sessions database schema version %d is newer than supported version %d", version, sessionsSchemaVersion)
+		}
+
+		if version < sessionsSchemaVersion {
+			log.Infof("Migrating sessions database from schema version %d to %d", version, sessionsSchemaVersion)
+
+			// The bucket must not be modified while iterating over it, so records are written once ForEach returns
+			migrated := make(map[string][]byte)
+			obsolete := make([][]byte, 0)
+			err = bucket.ForEach(func(k, v []byte) error {
+				data, err := migrateSessionRecord(v)
+				if errors.Is(err, errObsoleteSessionRecord) {
+					obsolete = append(obsolete, append([]byte(nil), k...))
+					return nil
+				}
+				if err != nil {
+					return fmt.Errorf("could not migrate session %s: %w", k, err)
+				}
+
+				migrated[string(k)] = data
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			for k, data := range migrated {
+				if err = bucket.Put([]byte(k), data); err != nil {
+					return err
+				}
+			}
+			for _, k := range obsolete {
+				log.Warnf("Removing obsolete session %s", k)
+				if err = bucket.Delete(k); err != nil {
+					return err
+				}
+			}
+		}
+
+		return meta.Put(schemaVersionKey, []byte(strconv.Itoa(sessionsSchemaVersion)))
+	})
+}
+
+// indexSharedSessions rebuilds the index of sessions shared with group chats, so it is consistent with sessions
+// stored before the index was introduced.
+func (p *boltSessionProvider) indexSharedSessions() error {
+	return p.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(sharedBucket) != nil {
+			if err := tx.DeleteBucket(sharedBucket); err != nil {
+				return err
+			}
+		}
+		index, err := tx.CreateBucket(sharedBucket)
+		if err != nil {
+			return err
+		}
+
+		return tx.Bucket(sessionsBucket).ForEach(func(k, v []byte) error {
+			var rec sessionRecord
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return fmt.Errorf("could not index session %s: %w", k, err)
+			}
+			if rec.SharedPermissions == 0 {
+				return nil
+			}
+
+			return index.Put(int64DbKey(rec.ChatId), int64DbKey(rec.UserId))
+		})
+	})
+}
+
+// updateSharedIndex adds the session to the index of shared sessions or removes it from there.
+func updateSharedIndex(tx *bolt.Tx, key sessionKey, shared bool) error {
+	index := tx.Bucket(sharedBucket)
+	if shared {
+		return index.Put(int64DbKey(key.chatId), int64DbKey(key.userId))
+	}
+
+	if v := index.Get(int64DbKey(key.chatId)); v != nil && string(v) == string(int64DbKey(key.userId)) {
+		return index.Delete(int64DbKey(key.chatId))
+	}
+
+	return nil
+}
+
+func migrateSessionRecord(data []byte) ([]byte, error) {
+	rec := make(map[string]interface{})
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, err
+	}
+
+	version := 1
+	if v, ok := rec["version"].(float64); ok {
+		version = int(v)
+	}
+
+	for ; version < sessionsSchemaVersion; version++ {
+		if err := sessionMigrations[version-1](rec); err != nil {
+			return nil, err
+		}
+	}
+	rec["version"] = sessionsSchemaVersion
+
+	return json.Marshal(rec)
+}
+
+// migrateSessionRecordV1 replaces relative OAuth token lifetime with the absolute expiry time.
+// Version 1 records don't store the time the token was issued at, so session creation time is used instead.
+func migrateSessionRecordV1(rec map[string]interface{}) error {
+	t, ok := rec["oauth_token"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	expiresIn, ok := t["expires_in"].(float64)
+	delete(t, "expires_in")
+	if !ok {
+		return nil
+	}
+
+	createdAt, ok := rec["created_at"].(string)
+	if !ok {
+		return errors.New("session creation time is missing")
+	}
+	issuedAt, err := time.Parse(time.RFC3339Nano, createdAt)
+	if err != nil {
+		return err
+	}
+
+	t["expires_at"] = issuedAt.Add(time.Duration(expiresIn) * time.Second)
+
+	return nil
+}
+
+// migrateSessionRecordV2 is a no-op. Version 3 adds optional device queues.
+func migrateSessionRecordV2(map[string]interface{}) error {
+	return nil
+}
+
+// migrateSessionRecordV3 is a no-op. Version 4 adds optional time zone and scheduled jobs.
+func migrateSessionRecordV3(map[string]interface{}) error {
+	return nil
+}
+
+// migrateSessionRecordV4 is a no-op. Version 5 adds optional default device group.
+func migrateSessionRecordV4(map[string]interface{}) error {
+	return nil
+}
+
+// migrateSessionRecordV5 is a no-op. Version 6 adds optional active household.
+func migrateSessionRecordV5(map[string]interface{}) error {
+	return nil
+}
+
+// migrateSessionRecordV6 is a no-op. Version 7 adds optional linked accounts.
+func migrateSessionRecordV6(map[string]interface{}) error {
+	return nil
+}
+
+// migrateSessionRecordV7 binds the session to the user. Group chat sessions used to be shared by all members
+// and don't know who has authorized them, so they are removed. Members have to log in again.
+func migrateSessionRecordV7(rec map[string]interface{}) error {
+	chatId, ok := rec["chat_id"].(float64)
+	if !ok {
+		return errors.New("session chat id is missing")
+	}
+	if chatId < 0 {
+		return errObsoleteSessionRecord
+	}
+
+	rec["user_id"] = chatId
+
+	return nil
+}
+
+// migrateSessionRecordV8 is a no-op. Version 9 adds optional invites and grants to the stations.
+func migrateSessionRecordV8(map[string]interface{}) error {
+	return nil
+}
+
+func (p *boltSessionProvider) SaveOrUpdate(newSession *session) {
+	err := p.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(sessionsBucket)
+		key := sessionDbKey(newSession.key())
+
+		createdAt := time.Now().UTC()
+		if data := bucket.Get(key); data != nil {
+			var existing sessionRecord
+			if err := json.Unmarshal(data, &existing); err == nil {
+				createdAt = existing.CreatedAt
+			}
+		}
+
+		data, err := json.Marshal(newSessionRecord(newSession, createdAt))
+		if err != nil {
+			return err
+		}
+		if err = bucket.Put(key, data); err != nil {
+			return err
+		}
+
+		return updateSharedIndex(tx, newSession.key(), newSession.sharedPermissions != 0)
+	})
+	if err != nil {
+		log.WithError(err).Errorf("Could not save session for chat %d and user %d", newSession.chatId, newSession.userId)
+	}
+}
+
+func (p *boltSessionProvider) TryGet(key sessionKey) (*session, bool) {
+	var rec *sessionRecord
+	err := p.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(sessionsBucket).Get(sessionDbKey(key))
+		if data == nil {
+			return nil
+		}
+
+		rec = &sessionRecord{}
+		return json.Unmarshal(data, rec)
+	})
+	if err != nil {
+		log.WithError(err).Errorf("Could not read session for chat %d and user %d", key.chatId, key.userId)
+		return nil, false
+	}
+	if rec == nil {
+		return nil, false
+	}
+
+	return rec.toSession(), true
+}
+
+func (p *boltSessionProvider) Delete(key sessionKey) {
+	err := p.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(sessionsBucket).Delete(sessionDbKey(key)); err != nil {
+			return err
+		}
+
+		return updateSharedIndex(tx, key, false)
+	})
+	if err != nil {
+		log.WithError(err).Errorf("Could not delete session for chat %d and user %d", key.chatId, key.userId)
+	}
+}
+
+func (p *boltSessionProvider) Count() int {
+	var n int
+	err := p.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(sessionsBucket).Stats().KeyN
+		return nil
+	})
+	if err != nil {
+		log.WithError(err).Error("Could not count sessions")
+	}
+
+	return n
+}
+
+func (p *boltSessionProvider) Keys() []sessionKey {
+	keys := make([]sessionKey, 0)
+	err := p.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).ForEach(func(k, _ []byte) error {
+			key, err := parseSessionDbKey(string(k))
+			if err != nil {
+				return err
+			}
+
+			keys = append(keys, key)
+			return nil
+		})
+	})
+	if err != nil {
+		log.WithError(err).Error("Could not list sessions")
+	}
+
+	return keys
+}
+
+func (p *boltSessionProvider) SharedKey(chatId int64) (sessionKey, bool) {
+	var key sessionKey
+	var ok bool
+	err := p.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(sharedBucket).Get(int64DbKey(chatId))
+		if v == nil {
+			return nil
+		}
+
+		userId, err := strconv.ParseInt(string(v), 10, 64)
+		if err != nil {
+			return err
+		}
+		key, ok = sessionKey{chatId, userId}, true
+
+		return nil
+	})
+	if err != nil {
+		log.WithError(err).Errorf("Could not find the session shared with chat %d", chatId)
+		return sessionKey{}, false
+	}
+
+	return key, ok
+}
+
+func (p *boltSessionProvider) Close() error {
+	return p.db.Close()
+}
+
+// sessionDbKey returns `<chat id>` for private chats and `<chat id>_<user id>` for group chats.
+func sessionDbKey(key sessionKey) []byte {
+	if !key.isGroup() {
+		return int64DbKey(key.chatId)
+	}
+
+	return []byte(fmt.Sprintf("%d_%d", key.chatId, key.userId))
+}
+
+func int64DbKey(v int64) []byte {
+	return []byte(strconv.FormatInt(v, 10))
+}
+
+func parseSessionDbKey(k string) (sessionKey, error) {
+	chat, user, group := strings.Cut(k, "_")
+
+	chatId, err := strconv.ParseInt(chat, 10, 64)
+	if err != nil {
+		return sessionKey{}, err
+	}
+	if !group {
+		return sessionKey{chatId, chatId}, nil
+	}
+
+	userId, err := strconv.ParseInt(user, 10, 64)
+	if err != nil {
+		return sessionKey{}, err
+	}
+
+	return sessionKey{chatId, userId}, nil
+}
+
+func newSessionRecord(s *session, createdAt time.Time) *sessionRecord {
+	rec := &sessionRecord{
+		Version:           sessionsSchemaVersion,
+		ChatId:            s.chatId,
+		UserId:            s.userId,
+		RefreshToken:      s.refreshToken,
+		DefaultDevice:     s.defaultDevice,
+		DefaultGroupId:    s.defaultGroupId,
+		HouseholdId:       s.householdId,
+		ExpiryNotifiedAt:  s.expiryNotifiedAt,
+		TimeZone:          s.timeZone,
+		AccountName:       s.accountName,
+		PendingAccount:    s.pendingAccountName,
+		SpanAccounts:      s.spanAccounts,
+		SharedPermissions: s.sharedPermissions,
+		GrantedBy:         s.grantedBy,
+		CreatedAt:         createdAt,
+	}
+	// Guests use the token of the owner, so it is never stored with the guest session
+	if s.oauthToken != nil && s.grantedBy == 0 {
+		rec.OAuthToken = &tokenRecord{s.oauthToken.value, s.oauthToken.expiresAt}
+	}
+	if len(s.queues) > 0 {
+		rec.Queues = make(map[string][]queueItemRecord, len(s.queues))
+		for deviceId, items := range s.queues {
+			for _, i := range items {
+				rec.Queues[deviceId] = append(rec.Queues[deviceId], queueItemRecord{i.url, i.title, i.duration})
+			}
+		}
+	}
+
+	for _, j := range s.jobs {
+		rec.Jobs = append(rec.Jobs, scheduledJobRecord{j.id, j.at, j.url, j.deviceId})
+	}
+	for _, i := range s.invites {
+		rec.Invites = append(rec.Invites, inviteRecord{i.token, i.account, i.deviceIds, i.expiresAt})
+	}
+	for _, g := range s.grants {
+		rec.Grants = append(rec.Grants, grantRecord{g.userId, g.name, g.account, g.deviceIds})
+	}
+	for _, a := range s.accounts {
+		ar := accountRecord{
+			Name:             a.name,
+			RefreshToken:     a.refreshToken,
+			ExpiryNotifiedAt: a.expiryNotifiedAt,
+			DefaultDevice:    a.defaultDevice,
+			DefaultGroupId:   a.defaultGroupId,
+			HouseholdId:      a.householdId,
+		}
+		if a.oauthToken != nil {
+			ar.OAuthToken = &tokenRecord{a.oauthToken.value, a.oauthToken.expiresAt}
+		}
+		rec.Accounts = append(rec.Accounts, ar)
+	}
+
+	return rec
+}
+
+func (r *sessionRecord) toSession() *session {
+	// CSRF token is short-lived and is never persisted. It is refreshed before use
+	var oauthToken *token
+	if r.OAuthToken != nil {
+		oauthToken = &token{r.OAuthToken.Value, r.OAuthToken.ExpiresAt}
+	}
+
+	var queues map[string][]queueItem
+	if len(r.Queues) > 0 {
+		queues = make(map[string][]queueItem, len(r.Queues))
+		for deviceId, items := range r.Queues {
+			for _, i := range items {
+				queues[deviceId] = append(queues[deviceId], queueItem{i.Url, i.Title, i.Duration})
+			}
+		}
+	}
+
+	var jobs []scheduledJob
+	for _, j := range r.Jobs {
+		jobs = append(jobs, scheduledJob{j.Id, j.At, j.Url, j.DeviceId})
+	}
+
+	var accounts []account
+	for _, a := range r.Accounts {
+		var t *token
+		if a.OAuthToken != nil {
+			t = &token{a.OAuthToken.Value, a.OAuthToken.ExpiresAt}
+		}
+		accounts = append(accounts, account{a.Name, t, a.RefreshToken, a.ExpiryNotifiedAt, a.DefaultDevice, a.DefaultGroupId, a.HouseholdId})
+	}
+
+	var invites []invite
+	for _, i := range r.Invites {
+		invites = append(invites, invite{i.Token, i.Account, i.DeviceIds, i.ExpiresAt})
+	}
+
+	var grants []grant
+	for _, g := range r.Grants {
+		grants = append(grants, grant{g.UserId, g.Name, g.Account, g.DeviceIds})
+	}
+
+	return &session{
+		chatId:             r.ChatId,
+		userId:             r.UserId,
+		oauthToken:         oauthToken,
+		refreshToken:       r.RefreshToken,
+		defaultDevice:      r.DefaultDevice,
+		defaultGroupId:     r.DefaultGroupId,
+		householdId:        r.HouseholdId,
+		expiryNotifiedAt:   r.ExpiryNotifiedAt,
+		queues:             queues,
+		timeZone:           r.TimeZone,
+		jobs:               jobs,
+		accountName:        r.AccountName,
+		accounts:           accounts,
+		pendingAccountName: r.PendingAccount,
+		spanAccounts:       r.SpanAccounts,
+		sharedPermissions:  r.SharedPermissions,
+		invites:            invites,
+		grants:             grants,
+		grantedBy:          r.GrantedBy,
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	bolt "go.etcd.io/bbolt"
+	"path/filepath"
+	"testing"
+)
+
+func newTestBoltSessionProvider(t *testing.T) *boltSessionProvider {
+	sp, err := NewBoltSessionProvider(filepath.Join(t.TempDir(), "sessions.db"))
+	if err != nil {
+		t.Fatalf("could not open sessions database: %v", err)
+	}
+	t.Cleanup(func() {
+		//goland:noinspection GoUnhandledErrorResult
+		sp.Close()
+	})
+
+	return sp
+}
+
+func TestBoltSessionProviderConcurrentAccess(t *testing.T) {
+	testConcurrentAccess(t, newTestBoltSessionProvider(t))
+}
+
+func TestBoltSessionProviderSharedIndex(t *testing.T) {
+	testSharedIndex(t, newTestBoltSessionProvider(t))
+}
+
+// The index is rebuilt on open, so sessions shared before it was introduced are found as well.
+func TestBoltSessionProviderRebuildsSharedIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.db")
+	sp, err := NewBoltSessionProvider(path)
+	if err != nil {
+		t.Fatalf("could not open sessions database: %v", err)
+	}
+
+	owner := sessionKey{-100, 1}
+	sp.SaveOrUpdate(NewSessionWithSharedPermissions(NewSession(owner, NewToken("owner", nil), nil, ""), playPermission))
+	err = sp.db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket(sharedBucket)
+	})
+	if err != nil {
+		t.Fatalf("could not drop the index: %v", err)
+	}
+	//goland:noinspection GoUnhandledErrorResult
+	sp.Close()
+
+	sp, err = NewBoltSessionProvider(path)
+	if err != nil {
+		t.Fatalf("could not reopen sessions database: %v", err)
+	}
+	//goland:noinspection GoUnhandledErrorResult
+	defer sp.Close()
+
+	if k, ok := sp.SharedKey(-100); !ok || k != owner {
+		t.Errorf("SharedKey() = %v, %t, want %v", k, ok, owner)
+	}
+}
+
+func TestBoltSessionProviderGroupSessions(t *testing.T) {
+	sp := newTestBoltSessionProvider(t)
+	private, member := sessionKey{42, 42}, sessionKey{-100, 42}
+	sp.SaveOrUpdate(NewSession(private, NewToken("private", nil), nil, ""))
+	sp.SaveOrUpdate(NewSession(member, NewToken("member", nil), nil, ""))
+
+	for key, want := range map[sessionKey]string{private: "private", member: "member"} {
+		s, ok := sp.TryGet(key)
+		if !ok {
+			t.Fatalf("session %v is not found", key)
+		}
+		if s.key() != key || s.oauthToken.value != want {
+			t.Errorf("session %v has key %v and token %s, want token %s", key, s.key(), s.oauthToken.value, want)
+		}
+	}
+}
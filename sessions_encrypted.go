@@ -0,0 +1,321 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// sealedTokenPrefix marks token values encrypted by encryptedSessionProvider.
+// Sealed value format is `enc:v1:<key id>:<base64(nonce|ciphertext)>`
+const sealedTokenPrefix = "enc:v1:"
+
+type encryptionKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// keyring holds the key used to seal new tokens and the retired ones
+// that are only used to open tokens sealed before the key rotation.
+type keyring struct {
+	current *encryptionKey
+	retired map[string]*encryptionKey
+}
+
+// NewKeyring creates a keyring from base64 encoded 256-bit keys.
+// oldKeys is a comma separated list of retired keys and might be empty.
+func NewKeyring(currentKey string, oldKeys string) (*keyring, error) {
+	current, err := newEncryptionKey(currentKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid current encryption key: %w", err)
+	}
+
+	kr := &keyring{current, make(map[string]*encryptionKey)}
+	for _, v := range strings.Split(oldKeys, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		k, err := newEncryptionKey(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retired encryption key: %w", err)
+		}
+		kr.retired[k.id] = k
+	}
+
+	return kr, nil
+}
+
+func newEncryptionKey(encoded string) (*encryptionKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) != 32 {
+		return nil, errors.New("key must be 32 bytes long")
+	}
+
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(raw)
+
+	return &encryptionKey{hex.EncodeToString(sum[:4]), aead}, nil
+}
+
+func (kr *keyring) seal(plaintext string) (string, error) {
+	nonce := make([]byte, kr.current.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	sealed := kr.current.aead.Seal(nonce, nonce, []byte(plaintext), []byte(kr.current.id))
+
+	return fmt.Sprintf("%s%s:%s", sealedTokenPrefix, kr.current.id, base64.StdEncoding.EncodeToString(sealed)), nil
+}
+
+// open decrypts the sealed value. Values that are not sealed are returned as is.
+// stale reports whether the value must be sealed again with the current key.
+func (kr *keyring) open(value string) (plaintext string, stale bool, err error) {
+	if !strings.HasPrefix(value, sealedTokenPrefix) {
+		return value, true, nil
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(value, sealedTokenPrefix), ":", 2)
+	if len(parts) != 2 {
+		return "", false, errors.New("malformed sealed token")
+	}
+	keyId, encoded := parts[0], parts[1]
+
+	k := kr.current
+	if keyId != k.id {
+		var ok bool
+		if k, ok = kr.retired[keyId]; !ok {
+			return "", false, fmt.Errorf("token is sealed with unknown key %s", keyId)
+		}
+	}
+
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", false, err
+	}
+	if len(data) < k.aead.NonceSize() {
+		return "", false, errors.New("malformed sealed token")
+	}
+
+	nonce, ciphertext := data[:k.aead.NonceSize()], data[k.aead.NonceSize():]
+	opened, err := k.aead.Open(nil, nonce, ciphertext, []byte(keyId))
+	if err != nil {
+		return "", false, err
+	}
+
+	return string(opened), k != kr.current, nil
+}
+
+// encryptedSessionProvider seals OAuth access and refresh tokens before passing sessions to the underlying provider.
+// Tokens stored in plain text or sealed with a retired key are sealed with the current key by Reseal.
+type encryptedSessionProvider struct {
+	inner SessionProvider
+	keys  *keyring
+}
+
+func NewEncryptedSessionProvider(inner SessionProvider, keys *keyring) *encryptedSessionProvider {
+	return &encryptedSessionProvider{inner, keys}
+}
+
+func (p *encryptedSessionProvider) SaveOrUpdate(newSession *session) {
+	sealed, err := p.sealSession(newSession)
+	if err != nil {
+		log.WithError(err).Errorf("Could not encrypt session for chat %d and user %d", newSession.chatId, newSession.userId)
+		return
+	}
+
+	p.inner.SaveOrUpdate(sealed)
+}
+
+func (p *encryptedSessionProvider) TryGet(key sessionKey) (*session, bool) {
+	s, ok := p.inner.TryGet(key)
+	if !ok {
+		return nil, false
+	}
+
+	// Sessions are never written back here, since the read might race with a newer session saved by the chat worker.
+	// Stale tokens are sealed with the current key by Reseal on start instead
+	opened, _, err := p.openSession(s)
+	if err != nil {
+		log.WithError(err).Errorf("Could not decrypt session for chat %d and user %d", key.chatId, key.userId)
+		return nil, false
+	}
+
+	return opened, true
+}
+
+// Reseal seals tokens stored in plain text or with a retired key with the current key and returns the number of
+// sessions sealed again. It must be called before sessions are used. An error is returned if any of the tokens cannot
+// be opened, e.g. it is sealed with a key missing in the keyring.
+func (p *encryptedSessionProvider) Reseal() (int, error) {
+	n := 0
+	for _, k := range p.inner.Keys() {
+		s, ok := p.inner.TryGet(k)
+		if !ok {
+			continue
+		}
+
+		opened, stale, err := p.openSession(s)
+		if err != nil {
+			return n, fmt.Errorf("could not decrypt session for chat %d and user %d: %w", k.chatId, k.userId, err)
+		}
+		if stale {
+			p.SaveOrUpdate(opened)
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+func (p *encryptedSessionProvider) Delete(key sessionKey) {
+	p.inner.Delete(key)
+}
+
+func (p *encryptedSessionProvider) Count() int {
+	return p.inner.Count()
+}
+
+func (p *encryptedSessionProvider) Keys() []sessionKey {
+	return p.inner.Keys()
+}
+
+func (p *encryptedSessionProvider) SharedKey(chatId int64) (sessionKey, bool) {
+	return p.inner.SharedKey(chatId)
+}
+
+// hasSealedTokens reports whether any of the sessions stored by the provider holds tokens sealed by encryptedSessionProvider.
+func hasSealedTokens(sp SessionProvider) bool {
+	sealed := func(oauthToken *token, refreshToken string) bool {
+		return (oauthToken != nil && strings.HasPrefix(oauthToken.value, sealedTokenPrefix)) ||
+			strings.HasPrefix(refreshToken, sealedTokenPrefix)
+	}
+
+	for _, k := range sp.Keys() {
+		s, ok := sp.TryGet(k)
+		if !ok {
+			continue
+		}
+
+		if sealed(s.oauthToken, s.refreshToken) {
+			return true
+		}
+		for _, a := range s.accounts {
+			if sealed(a.oauthToken, a.refreshToken) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (p *encryptedSessionProvider) sealSession(s *session) (*session, error) {
+	sealed := *s
+
+	var err error
+	sealed.oauthToken, sealed.refreshToken, err = p.sealTokens(s.oauthToken, s.refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed.accounts = make([]account, len(s.accounts))
+	for i, a := range s.accounts {
+		a.oauthToken, a.refreshToken, err = p.sealTokens(a.oauthToken, a.refreshToken)
+		if err != nil {
+			return nil, err
+		}
+		sealed.accounts[i] = a
+	}
+
+	return &sealed, nil
+}
+
+func (p *encryptedSessionProvider) sealTokens(oauthToken *token, refreshToken string) (*token, string, error) {
+	if oauthToken != nil {
+		value, err := p.keys.seal(oauthToken.value)
+		if err != nil {
+			return nil, "", err
+		}
+		oauthToken = &token{value, oauthToken.expiresAt}
+	}
+
+	if refreshToken != "" {
+		value, err := p.keys.seal(refreshToken)
+		if err != nil {
+			return nil, "", err
+		}
+		refreshToken = value
+	}
+
+	return oauthToken, refreshToken, nil
+}
+
+func (p *encryptedSessionProvider) openSession(s *session) (*session, bool, error) {
+	opened := *s
+
+	var err error
+	var stale bool
+	opened.oauthToken, opened.refreshToken, stale, err = p.openTokens(s.oauthToken, s.refreshToken)
+	if err != nil {
+		return nil, false, err
+	}
+
+	opened.accounts = make([]account, len(s.accounts))
+	for i, a := range s.accounts {
+		var st bool
+		a.oauthToken, a.refreshToken, st, err = p.openTokens(a.oauthToken, a.refreshToken)
+		if err != nil {
+			return nil, false, err
+		}
+		opened.accounts[i] = a
+		stale = stale || st
+	}
+
+	return &opened, stale, nil
+}
+
+// openTokens returns stale if any of the tokens is not sealed with the current key.
+func (p *encryptedSessionProvider) openTokens(oauthToken *token, refreshToken string) (*token, string, bool, error) {
+	var stale bool
+
+	if oauthToken != nil {
+		value, st, err := p.keys.open(oauthToken.value)
+		if err != nil {
+			return nil, "", false, err
+		}
+		oauthToken = &token{value, oauthToken.expiresAt}
+		stale = stale || st
+	}
+
+	if refreshToken != "" {
+		value, st, err := p.keys.open(refreshToken)
+		if err != nil {
+			return nil, "", false, err
+		}
+		refreshToken = value
+		stale = stale || st
+	}
+
+	return oauthToken, refreshToken, stale, nil
+}
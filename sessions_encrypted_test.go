@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func newTestKey(t *testing.T) string {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		t.Fatalf("could not generate key: %v", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+func newTestKeyring(t *testing.T, current string, old string) *keyring {
+	kr, err := NewKeyring(current, old)
+	if err != nil {
+		t.Fatalf("could not create keyring: %v", err)
+	}
+
+	return kr
+}
+
+func TestEncryptedSessionProviderConcurrentAccess(t *testing.T) {
+	inner := NewInMemorySessionProvider()
+	testConcurrentAccess(t, NewEncryptedSessionProvider(inner, newTestKeyring(t, newTestKey(t), "")))
+
+	for _, k := range inner.Keys() {
+		s, _ := inner.TryGet(k)
+		if !strings.HasPrefix(s.oauthToken.value, sealedTokenPrefix) {
+			t.Errorf("session %v is stored with a plain text token", k)
+		}
+	}
+}
+
+// Sessions sealed with a retired key are read concurrently with the ones sealed with the current key.
+func TestEncryptedSessionProviderConcurrentKeyRotation(t *testing.T) {
+	oldKey := newTestKey(t)
+	inner := NewInMemorySessionProvider()
+
+	old := NewEncryptedSessionProvider(inner, newTestKeyring(t, oldKey, ""))
+	for w := 0; w < concurrentWriters; w++ {
+		key := sessionKey{int64(w + 1), int64(w + 1)}
+		old.SaveOrUpdate(NewSession(key, NewToken("token", nil), nil, "refresh"))
+	}
+
+	testConcurrentAccess(t, NewEncryptedSessionProvider(inner, newTestKeyring(t, newTestKey(t), oldKey)))
+}
+
+func TestEncryptedSessionProviderReseal(t *testing.T) {
+	oldKey, currentKey := newTestKey(t), newTestKey(t)
+	inner := NewInMemorySessionProvider()
+	inner.SaveOrUpdate(NewSession(sessionKey{1, 1}, NewToken("plain", nil), nil, "refresh"))
+	NewEncryptedSessionProvider(inner, newTestKeyring(t, oldKey, "")).
+		SaveOrUpdate(NewSession(sessionKey{2, 2}, NewToken("old", nil), nil, ""))
+	NewEncryptedSessionProvider(inner, newTestKeyring(t, currentKey, "")).
+		SaveOrUpdate(NewSession(sessionKey{3, 3}, NewToken("current", nil), nil, ""))
+
+	sp := NewEncryptedSessionProvider(inner, newTestKeyring(t, currentKey, oldKey))
+	n, err := sp.Reseal()
+	if err != nil {
+		t.Fatalf("Reseal() error = %v", err)
+	}
+	if n != 2 {
+		t.Errorf("Reseal() = %d, want 2", n)
+	}
+
+	// Only the current key is needed once the sessions are sealed again
+	current := NewEncryptedSessionProvider(inner, newTestKeyring(t, currentKey, ""))
+	if n, err := current.Reseal(); n != 0 || err != nil {
+		t.Errorf("Reseal() = %d, %v after sealing, want nothing to seal", n, err)
+	}
+	for key, want := range map[sessionKey]string{{1, 1}: "plain", {2, 2}: "old", {3, 3}: "current"} {
+		s, ok := current.TryGet(key)
+		if !ok || s.oauthToken.value != want {
+			t.Errorf("session %v is not opened with the current key", key)
+		}
+	}
+}
+
+func TestEncryptedSessionProviderResealUnknownKey(t *testing.T) {
+	inner := NewInMemorySessionProvider()
+	NewEncryptedSessionProvider(inner, newTestKeyring(t, newTestKey(t), "")).
+		SaveOrUpdate(NewSession(sessionKey{1, 1}, NewToken("token", nil), nil, ""))
+
+	if _, err := NewEncryptedSessionProvider(inner, newTestKeyring(t, newTestKey(t), "")).Reseal(); err == nil {
+		t.Errorf("Reseal() succeeded with tokens sealed by an unknown key")
+	}
+}
+
+// Reads must not write sessions back, or they would overwrite newer sessions saved by chat workers.
+func TestEncryptedSessionProviderTryGetDoesNotWrite(t *testing.T) {
+	oldKey := newTestKey(t)
+	inner := NewInMemorySessionProvider()
+	NewEncryptedSessionProvider(inner, newTestKeyring(t, oldKey, "")).
+		SaveOrUpdate(NewSession(sessionKey{1, 1}, NewToken("token", nil), nil, ""))
+	before, _ := inner.TryGet(sessionKey{1, 1})
+
+	NewEncryptedSessionProvider(inner, newTestKeyring(t, newTestKey(t), oldKey)).TryGet(sessionKey{1, 1})
+
+	if after, _ := inner.TryGet(sessionKey{1, 1}); after.oauthToken.value != before.oauthToken.value {
+		t.Errorf("session has been written on read")
+	}
+}
+
+func TestEncryptedSessionProviderSharedIndex(t *testing.T) {
+	testSharedIndex(t, NewEncryptedSessionProvider(NewInMemorySessionProvider(), newTestKeyring(t, newTestKey(t), "")))
+}
+
+func TestHasSealedTokens(t *testing.T) {
+	inner := NewInMemorySessionProvider()
+	inner.SaveOrUpdate(NewSession(sessionKey{1, 1}, NewToken("plain", nil), nil, ""))
+	if hasSealedTokens(inner) {
+		t.Errorf("plain text tokens are reported as sealed")
+	}
+
+	NewEncryptedSessionProvider(inner, newTestKeyring(t, newTestKey(t), "")).
+		SaveOrUpdate(NewSession(sessionKey{2, 2}, NewToken("sealed", nil), nil, ""))
+	if !hasSealedTokens(inner) {
+		t.Errorf("sealed tokens are not found")
+	}
+}
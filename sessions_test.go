@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+const (
+	concurrentWriters = 8
+	writesPerWriter   = 50
+)
+
+// testConcurrentAccess runs writers saving, reading and deleting their own sessions along with a session shared by all
+// of them, while readers list and count sessions. Run it with -race to detect unsynchronized access.
+func testConcurrentAccess(t *testing.T, sp SessionProvider) {
+	shared := sessionKey{-100, 1}
+
+	var wg sync.WaitGroup
+	for w := 0; w < concurrentWriters; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+
+			key := sessionKey{int64(w + 1), int64(w + 1)}
+			for i := 0; i < writesPerWriter; i++ {
+				value := fmt.Sprintf("token-%d-%d", w, i)
+				sp.SaveOrUpdate(NewSession(key, NewToken(value, nil), nil, "refresh-"+value))
+
+				s, ok := sp.TryGet(key)
+				if !ok {
+					t.Errorf("session %v is not found", key)
+					return
+				}
+				if s.oauthToken.value != value {
+					t.Errorf("session %v has token %s, want %s", key, s.oauthToken.value, value)
+				}
+				// Sessions returned by TryGet are copies, so modifying them must not affect other goroutines
+				s.timeZone = value
+
+				sp.SaveOrUpdate(NewSessionWithTimeZone(NewSession(shared, NewToken(value, nil), nil, ""), value))
+				if s, ok := sp.TryGet(shared); ok {
+					s.timeZone = ""
+				}
+
+				if i%10 == 0 {
+					sp.Delete(key)
+				}
+			}
+		}(w)
+	}
+
+	for r := 0; r < concurrentWriters/2; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := 0; i < writesPerWriter; i++ {
+				for _, k := range sp.Keys() {
+					sp.TryGet(k)
+				}
+				sp.Count()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Every writer has left its last session in place
+	if n := sp.Count(); n != concurrentWriters+1 {
+		t.Errorf("Count() = %d, want %d", n, concurrentWriters+1)
+	}
+	for w := 0; w < concurrentWriters; w++ {
+		key := sessionKey{int64(w + 1), int64(w + 1)}
+		want := fmt.Sprintf("token-%d-%d", w, writesPerWriter-1)
+
+		s, ok := sp.TryGet(key)
+		if !ok {
+			t.Fatalf("session %v is not found", key)
+		}
+		if s.oauthToken.value != want || s.refreshToken != "refresh-"+want {
+			t.Errorf("session %v has tokens %s and %s, want %s", key, s.oauthToken.value, s.refreshToken, want)
+		}
+	}
+
+	s, ok := sp.TryGet(shared)
+	if !ok {
+		t.Fatalf("shared session is not found")
+	}
+	if s.timeZone != s.oauthToken.value {
+		t.Errorf("shared session has been written partially: time zone %s, token %s", s.timeZone, s.oauthToken.value)
+	}
+}
+
+func TestInMemorySessionProviderConcurrentAccess(t *testing.T) {
+	testConcurrentAccess(t, NewInMemorySessionProvider())
+}
+
+func TestInMemorySessionProviderReturnsCopies(t *testing.T) {
+	sp := NewInMemorySessionProvider()
+	key := sessionKey{1, 1}
+	sp.SaveOrUpdate(NewSessionWithTimeZone(NewSession(key, NewToken("token", nil), nil, ""), "UTC"))
+
+	s, _ := sp.TryGet(key)
+	s.timeZone = "Europe/Moscow"
+
+	if s, _ := sp.TryGet(key); s.timeZone != "UTC" {
+		t.Errorf("stored session has been modified through the copy: time zone %s", s.timeZone)
+	}
+}
+
+// testSharedIndex checks the provider finds the session shared with the group chat as it is shared, stopped sharing
+// and deleted.
+func testSharedIndex(t *testing.T, sp SessionProvider) {
+	owner, member, other := sessionKey{-100, 1}, sessionKey{-100, 2}, sessionKey{-200, 1}
+	sp.SaveOrUpdate(NewSessionWithSharedPermissions(NewSession(owner, NewToken("owner", nil), nil, ""), playPermission))
+	sp.SaveOrUpdate(NewSession(member, NewToken("member", nil), nil, ""))
+	sp.SaveOrUpdate(NewSession(other, NewToken("other", nil), nil, ""))
+
+	if k, ok := sp.SharedKey(-100); !ok || k != owner {
+		t.Errorf("SharedKey() = %v, %t, want %v", k, ok, owner)
+	}
+	if k, ok := sp.SharedKey(-200); ok {
+		t.Errorf("SharedKey() = %v for the chat nobody shares with", k)
+	}
+
+	// Saving sessions of other members keeps the owner in place
+	sp.SaveOrUpdate(NewSession(member, NewToken("member", nil), nil, ""))
+	if k, ok := sp.SharedKey(-100); !ok || k != owner {
+		t.Errorf("SharedKey() = %v, %t after another member is saved, want %v", k, ok, owner)
+	}
+
+	s, _ := sp.TryGet(owner)
+	sp.SaveOrUpdate(NewSessionWithSharedPermissions(s, 0))
+	if k, ok := sp.SharedKey(-100); ok {
+		t.Errorf("SharedKey() = %v after sharing is stopped", k)
+	}
+
+	sp.SaveOrUpdate(NewSessionWithSharedPermissions(s, controlPermission))
+	sp.Delete(owner)
+	if k, ok := sp.SharedKey(-100); ok {
+		t.Errorf("SharedKey() = %v after the session is deleted", k)
+	}
+}
+
+func TestInMemorySessionProviderSharedIndex(t *testing.T) {
+	testSharedIndex(t, NewInMemorySessionProvider())
+}
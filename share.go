@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	tbot "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// invitePrefix starts the deep link parameter of invite links, i.e. `inv_<owner id>_<token>`
+	invitePrefix = "inv_"
+	inviteTTL    = 24 * time.Hour
+	maxInvites   = 10
+
+	// guestPermissions are the things guests may do with the stations shared with them
+	guestPermissions = playPermission | controlPermission
+)
+
+// Share actions passed with ShareCallback
+const (
+	inviteAction      = "inv"
+	revokeGrantAction = "rv"
+	allStations       = "all"
+)
+
+// invite is a one-time link to the stations of the account, see /share.
+type invite struct {
+	token     string
+	account   string
+	deviceIds []string
+	expiresAt time.Time
+}
+
+// grant gives the user who has accepted an invite access to the stations of the account.
+type grant struct {
+	userId    int64
+	name      string
+	account   string
+	deviceIds []string
+}
+
+func newInviteToken() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+// userDisplayName returns the username of the user falling back to the full name.
+func userDisplayName(u *tbot.User) string {
+	if u.UserName != "" {
+		return "@" + u.UserName
+	}
+
+	return strings.TrimSpace(u.FirstName + " " + u.LastName)
+}
+
+func (s *session) findGrant(userId int64) *grant {
+	for i, g := range s.grants {
+		if g.userId == userId {
+			return &s.grants[i]
+		}
+	}
+
+	return nil
+}
+
+// withoutGrant returns the grants except for the one of the user.
+func (s *session) withoutGrant(userId int64) []grant {
+	grants := make([]grant, 0, len(s.grants))
+	for _, g := range s.grants {
+		if g.userId != userId {
+			grants = append(grants, g)
+		}
+	}
+
+	return grants
+}
+
+// filterGrantedDevices returns the stations the guest may use. All devices are returned for other sessions.
+func filterGrantedDevices(s *session, devices []device) []device {
+	if s.grantedBy == 0 {
+		return devices
+	}
+
+	filtered := make([]device, 0, len(devices))
+	for _, d := range devices {
+		if containsString(s.grantedDevices, d.Id) {
+			filtered = append(filtered, d)
+		}
+	}
+
+	return filtered
+}
+
+// isGuestAllowed reports whether guests may do what the update asks for. They may always stop using the shared stations.
+func isGuestAllowed(update *tbot.Update) bool {
+	if update.Message != nil && (update.Message.Command() == LogoutCmd || update.Message.Command() == ResetCmd) {
+		return true
+	}
+
+	return requiredPermission(update)&guestPermissions != 0
+}
+
+// loadSession returns the stored session. Guest sessions get the token of the owner, see guestSession.
+func (b *bot) loadSession(key sessionKey) (*session, bool, error) {
+	s, ok := b.sessionProvider.TryGet(key)
+	if !ok || s.grantedBy == 0 {
+		return s, ok, nil
+	}
+
+	gs, err := b.guestSession(s)
+	return gs, true, err
+}
+
+// guestSession fills in the token of the account the owner has shared and the stations the guest may use.
+// The guest session is removed if access has been revoked in the meantime, e.g. the owner has logged out.
+func (b *bot) guestSession(s *session) (*session, error) {
+	var g *grant
+	var account *session
+	owner, ok := b.sessionProvider.TryGet(sessionKey{s.grantedBy, s.grantedBy})
+	if ok {
+		g = owner.findGrant(s.userId)
+	}
+	if g != nil {
+		account, ok = NewSessionWithActiveAccount(owner, g.account)
+	}
+	if g == nil || !ok {
+		b.removeGuestSession(s)
+		return nil, NewBotError(fmt.Sprintf("Access to the stations shared with you has been revoked. Please, log in with /%s to use your own.", StartCmd))
+	}
+
+	gs := *s
+	gs.oauthToken, gs.csrfToken, gs.refreshToken = account.oauthToken, nil, ""
+	gs.grantedDevices = g.deviceIds
+
+	return &gs, nil
+}
+
+func (b *bot) removeGuestSession(s *session) {
+	for _, j := range s.jobs {
+		b.jobTimers.Stop(j.id)
+	}
+	for deviceId := range s.queues {
+		b.queueTimers.Stop(s.chatId, deviceId)
+	}
+	b.sessionProvider.Delete(s.key())
+}
+
+// stationNames returns comma separated names of the stations of the account.
+func (b *bot) stationNames(s *session, account string, deviceIds []string) string {
+	if as, ok := NewSessionWithActiveAccount(s, account); ok {
+		s = as
+	}
+
+	names := make([]string, 0, len(deviceIds))
+	for _, id := range deviceIds {
+		names = append(names, fmt.Sprintf("`%s`", b.stationName(s, id)))
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// handleShareCommand lists the users the stations are shared with and offers to invite someone else.
+func (b *bot) handleShareCommand(s *session) error {
+	if s.key().isGroup() {
+		return NewBotError(fmt.Sprintf("Please, use this command in a private chat with me. Use /%s to share your stations with the group.", GroupCmd))
+	}
+
+	devices, err := b.getYandexStations(s)
+	if err != nil {
+		return err
+	}
+
+	buf := bytes.Buffer{}
+	rows := make([][]tbot.InlineKeyboardButton, 0)
+	if len(s.grants) > 0 {
+		buf.WriteString("Your stations are shared with:\n")
+		for i, g := range s.grants {
+			buf.WriteString(fmt.Sprintf("%d. %s: %s\n", i+1, g.name, b.stationNames(s, g.account, g.deviceIds)))
+
+			data := fmt.Sprintf("%s:%s:%d", ShareCallback, revokeGrantAction, g.userId)
+			rows = append(rows, tbot.NewInlineKeyboardRow(tbot.NewInlineKeyboardButtonData(fmt.Sprintf("Revoke access of %s", g.name), data)))
+		}
+		buf.WriteString("\n")
+	}
+
+	buf.WriteString("Select the station you want to invite someone to. They will be able to play media and control playback on it without a Yandex login.")
+
+	for _, d := range devices {
+		data := fmt.Sprintf("%s:%s:%s", ShareCallback, inviteAction, d.Id)
+		rows = append(rows, tbot.NewInlineKeyboardRow(tbot.NewInlineKeyboardButtonData(fmt.Sprintf("Invite to %s", d.Name), data)))
+	}
+	if len(devices) > 1 {
+		data := fmt.Sprintf("%s:%s:%s", ShareCallback, inviteAction, allStations)
+		rows = append(rows, tbot.NewInlineKeyboardRow(tbot.NewInlineKeyboardButtonData("Invite to all stations", data)))
+	}
+
+	msg := tbot.NewMessage(s.chatId, buf.String())
+	msg.ReplyMarkup = tbot.NewInlineKeyboardMarkup(rows...)
+
+	//goland:noinspection GoUnhandledErrorResult
+	b.api.Send(msg)
+
+	return nil
+}
+
+// handleShareCallback handles `inv:<device id>`, `inv:all` and `rv:<user id>` data.
+func (b *bot) handleShareCallback(s *session, data string) {
+	action, value, _ := strings.Cut(data, ":")
+
+	switch action {
+	case inviteAction:
+		b.handleError(s.chatId, b.createInvite(s, value))
+	case revokeGrantAction:
+		userId, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			log.WithError(err).Error("Could not process callback")
+			return
+		}
+		b.revokeGrant(s, userId)
+	}
+}
+
+// createInvite sends a new one-time link to the station with the id or to all stations.
+func (b *bot) createInvite(s *session, deviceId string) error {
+	devices, err := b.getYandexStations(s)
+	if err != nil {
+		return err
+	}
+
+	deviceIds := make([]string, 0)
+	for _, d := range devices {
+		if deviceId == allStations || d.Id == deviceId {
+			deviceIds = append(deviceIds, d.Id)
+		}
+	}
+	if len(deviceIds) == 0 {
+		return NewBotError("Selected device is not currently available. Please, try again later.")
+	}
+
+	invites := make([]invite, 0, len(s.invites)+1)
+	for _, i := range s.invites {
+		if time.Now().Before(i.expiresAt) {
+			invites = append(invites, i)
+		}
+	}
+	if len(invites) >= maxInvites {
+		return NewBotError(fmt.Sprintf("You have %d unused invite links already. Please, wait for them to be accepted or to expire.", len(invites)))
+	}
+
+	token, err := newInviteToken()
+	if err != nil {
+		return err
+	}
+	invites = append(invites, invite{token, s.activeAccountName(), deviceIds, time.Now().UTC().Add(inviteTTL)})
+	b.sessionProvider.SaveOrUpdate(NewSessionWithInvites(s, invites))
+
+	b.send(s.chatId, fmt.Sprintf("Send the link down below to the person you want to share %s with. "+
+		"It can only be used once and expires in 24 hours.", b.stationNames(s, s.activeAccountName(), deviceIds)))
+	b.send(s.chatId, fmt.Sprintf("%s?start=%s%d_%s", b.botUrl(), invitePrefix, s.userId, token))
+
+	return nil
+}
+
+// handleInviteLink accepts the invite passed with /start. The owner session is only modified by its own worker.
+func (b *bot) handleInviteLink(key sessionKey, from *tbot.User, args string) error {
+	if key.isGroup() {
+		return NewBotError("Please, open the invite link in a private chat with me.")
+	}
+
+	ownerPart, token, _ := strings.Cut(strings.TrimPrefix(args, invitePrefix), "_")
+	ownerId, err := strconv.ParseInt(ownerPart, 10, 64)
+	if err != nil {
+		return NewBotError("The invite link is invalid or has expired. Please, ask for a new one.")
+	}
+	if ownerId == key.userId {
+		return NewBotError("You can't accept your own invite. Please, send the link to the person you want to share your stations with.")
+	}
+
+	if s, ok := b.sessionProvider.TryGet(key); ok {
+		if s.grantedBy == 0 {
+			return NewBotError(fmt.Sprintf("You are logged in with your own Yandex account. Please, /%s first to use the stations shared with you.", LogoutCmd))
+		}
+		if s.grantedBy != ownerId {
+			return NewBotError(fmt.Sprintf("You already use the stations shared by someone else. Please, /%s first to use these ones.", LogoutCmd))
+		}
+	}
+
+	name := userDisplayName(from)
+	b.dispatcher.EnqueueAsync(ownerId, func() {
+		b.handleError(key.chatId, b.acceptInvite(key, name, ownerId, token))
+	})
+
+	return nil
+}
+
+// acceptInvite grants the user access to the stations of the invite. It runs on the owner worker, while the guest
+// session is created by the worker of the guest chat. Stations of the same account granted before are kept.
+func (b *bot) acceptInvite(key sessionKey, name string, ownerId int64, token string) error {
+	invalid := NewBotError("The invite link is invalid or has expired. Please, ask for a new one.")
+
+	owner, ok := b.sessionProvider.TryGet(sessionKey{ownerId, ownerId})
+	if !ok {
+		return invalid
+	}
+
+	var inv *invite
+	invites := make([]invite, 0, len(owner.invites))
+	for i, v := range owner.invites {
+		if v.token == token {
+			inv = &owner.invites[i]
+			continue
+		}
+		invites = append(invites, v)
+	}
+	if inv == nil || time.Now().After(inv.expiresAt) {
+		return invalid
+	}
+
+	g := grant{key.userId, name, inv.account, inv.deviceIds}
+	if existing := owner.findGrant(key.userId); existing != nil && existing.account == g.account {
+		g.deviceIds = append([]string(nil), existing.deviceIds...)
+		for _, id := range inv.deviceIds {
+			if !containsString(g.deviceIds, id) {
+				g.deviceIds = append(g.deviceIds, id)
+			}
+		}
+	}
+	grants := append(owner.withoutGrant(key.userId), g)
+	owner = NewSessionWithGrants(NewSessionWithInvites(owner, invites), grants)
+	b.sessionProvider.SaveOrUpdate(owner)
+
+	names := b.stationNames(owner, g.account, inv.deviceIds)
+	b.dispatcher.EnqueueAsync(key.chatId, func() {
+		if _, ok := b.sessionProvider.TryGet(key); !ok {
+			b.sessionProvider.SaveOrUpdate(NewGuestSession(key, ownerId))
+		}
+
+		b.send(key.chatId, fmt.Sprintf("Welcome! You can now use %s. Send me a link to play it. Use /%s to stop using shared stations.", names, LogoutCmd))
+	})
+	b.send(owner.chatId, fmt.Sprintf("%s has accepted your invite to %s. Use /%s to manage access.", name, names, ShareCmd))
+
+	return nil
+}
+
+// revokeGrant removes the access of the user to the stations. The guest session is removed by the worker of the guest chat.
+func (b *bot) revokeGrant(s *session, userId int64) {
+	g := s.findGrant(userId)
+	if g == nil {
+		b.send(s.chatId, "The user doesn't have access to your stations anymore.")
+		return
+	}
+
+	b.sessionProvider.SaveOrUpdate(NewSessionWithGrants(s, s.withoutGrant(userId)))
+
+	guestKey, ownerId := sessionKey{userId, userId}, s.userId
+	b.dispatcher.EnqueueAsync(guestKey.chatId, func() {
+		if gs, ok := b.sessionProvider.TryGet(guestKey); ok && gs.grantedBy == ownerId {
+			b.removeGuestSession(gs)
+			b.send(guestKey.chatId, "Access to the stations shared with you has been revoked.")
+		}
+	})
+
+	b.send(s.chatId, fmt.Sprintf("Access of %s has been revoked.", g.name))
+}
+
+// leaveGrant stops using the shared stations. The grant is removed from the owner session by its own worker.
+func (b *bot) leaveGrant(s *session) {
+	b.removeGuestSession(s)
+
+	ownerId, userId := s.grantedBy, s.userId
+	b.dispatcher.EnqueueAsync(ownerId, func() {
+		owner, ok := b.sessionProvider.TryGet(sessionKey{ownerId, ownerId})
+		if !ok {
+			return
+		}
+
+		g := owner.findGrant(userId)
+		if g == nil {
+			return
+		}
+		b.sessionProvider.SaveOrUpdate(NewSessionWithGrants(owner, owner.withoutGrant(userId)))
+
+		b.send(owner.chatId, fmt.Sprintf("%s has stopped using your stations.", g.name))
+	})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// vkVideoIdPattern matches VK video ids such as `-123_456` in `/video-123_456`, `/clip-123_456` or `z=video-123_456`
+var vkVideoIdPattern = regexp.MustCompile(`(?:video|clip)(-?\d+_\d+)`)
+
+var vkHosts = []string{"vk.com", "vk.ru", "vkvideo.ru", "vk.cc"}
+
+type vkVideoProvider struct {
+	httpClient *http.Client
+}
+
+// NewVKVideoProvider creates VK Video provider.
+// The http client is used to resolve vk.cc short links and must not follow redirects.
+func NewVKVideoProvider() *vkVideoProvider {
+	return &vkVideoProvider{&http.Client{
+		Timeout: 5 * time.Second,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}}
+}
+
+func (p *vkVideoProvider) Name() string {
+	return "VK Video"
+}
+
+func (p *vkVideoProvider) Match(u *url.URL) bool {
+	host := strings.TrimPrefix(strings.TrimPrefix(u.Host, "www."), "m.")
+	for _, h := range vkHosts {
+		if host == h {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *vkVideoProvider) Normalize(u *url.URL) (string, error) {
+	if strings.HasSuffix(u.Host, "vk.cc") {
+		resolved, err := p.resolveShortLink(u)
+		if err != nil {
+			log.WithError(err).Errorf("Could not resolve VK short link %s", u)
+			return "", NewBotError("Could not open the VK short link. Please, send me the full one.")
+		}
+		u = resolved
+	}
+
+	var videoId string
+	if u.Path == "/video_ext.php" {
+		// Embed links carry owner and video ids as query parameters
+		q := u.Query()
+		if q.Get("oid") != "" && q.Get("id") != "" {
+			videoId = fmt.Sprintf("%s_%s", q.Get("oid"), q.Get("id"))
+		}
+	} else if m := vkVideoIdPattern.FindStringSubmatch(u.Path + "?" + u.RawQuery); m != nil {
+		videoId = m[1]
+	}
+
+	if videoId == "" {
+		return "", NewBotError("This VK link doesn't look like a video. Please, send me a link to the video itself.")
+	}
+
+	return fmt.Sprintf("https://vk.com/video%s", videoId), nil
+}
+
+func (p *vkVideoProvider) PlayerId() string {
+	return "vk"
+}
+
+func (p *vkVideoProvider) ProviderItemId(normalizedUrl string) string {
+	return normalizedUrl
+}
+
+func (p *vkVideoProvider) resolveShortLink(u *url.URL) (*url.URL, error) {
+	resp, err := p.httpClient.Head(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	location, err := resp.Location()
+	if err != nil {
+		return nil, err
+	}
+	location.Host = strings.ToLower(location.Host)
+
+	if !p.Match(location) || strings.HasSuffix(location.Host, "vk.cc") {
+		return nil, fmt.Errorf("short link points to unexpected location %s", location)
+	}
+
+	return location, nil
+}
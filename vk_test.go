@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// roundTripFunc answers http requests without going to the network.
+type roundTripFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// shortLinkRoundTripper redirects vk.cc short links to the locations.
+func shortLinkRoundTripper(locations map[string]string) http.RoundTripper {
+	return roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		location, ok := locations[r.URL.String()]
+		if !ok {
+			return &http.Response{StatusCode: http.StatusNotFound, Body: http.NoBody, Header: make(http.Header), Request: r}, nil
+		}
+
+		h := make(http.Header)
+		h.Set("Location", location)
+		return &http.Response{StatusCode: http.StatusFound, Body: http.NoBody, Header: h, Request: r}, nil
+	})
+}
+
+func TestVKVideoProviderNormalize(t *testing.T) {
+	p := NewVKVideoProvider()
+	p.httpClient.Transport = shortLinkRoundTripper(map[string]string{
+		"https://vk.cc/video":   "https://vk.com/video-123_456",
+		"https://vk.cc/profile": "https://vk.com/id1",
+		"https://vk.cc/away":    "https://example.com/video-123_456",
+		"https://vk.cc/loop":    "https://vk.cc/video",
+	})
+
+	tests := []struct {
+		name    string
+		link    string
+		want    string
+		wantErr bool
+	}{
+		{"video", "https://vk.com/video-123_456", "https://vk.com/video-123_456", false},
+		{"user video", "https://vk.com/video123_456", "https://vk.com/video123_456", false},
+		{"mobile", "https://m.vk.com/video-123_456", "https://vk.com/video-123_456", false},
+		{"vk.ru", "https://vk.ru/video-123_456", "https://vk.com/video-123_456", false},
+		{"vkvideo", "https://vkvideo.ru/video-123_456?list=ln-abc", "https://vk.com/video-123_456", false},
+		{"clip", "https://vk.com/clip-123_456", "https://vk.com/video-123_456", false},
+		{"video in feed", "https://vk.com/feed?z=video-123_456%2Fpl_cat_trends", "https://vk.com/video-123_456", false},
+		{"video in wall", "https://vk.com/wall-1_2?z=video-123_456", "https://vk.com/video-123_456", false},
+		{"embed", "https://vk.com/video_ext.php?oid=-123&id=456&hash=abc", "https://vk.com/video-123_456", false},
+		{"short link", "https://vk.cc/video", "https://vk.com/video-123_456", false},
+		{"embed without id", "https://vk.com/video_ext.php?oid=-123", "", true},
+		{"profile", "https://vk.com/id1", "", true},
+		{"short link to profile", "https://vk.cc/profile", "", true},
+		{"short link to another site", "https://vk.cc/away", "", true},
+		{"short link to short link", "https://vk.cc/loop", "", true},
+		{"unknown short link", "https://vk.cc/unknown", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.link)
+			if err != nil {
+				t.Fatalf("invalid test link: %v", err)
+			}
+			if !p.Match(u) {
+				t.Fatalf("link %s is not matched", tt.link)
+			}
+
+			got, err := p.Normalize(u)
+			if tt.wantErr {
+				if _, ok := err.(*botError); !ok {
+					t.Errorf("Normalize() = %s, %v, want botError", got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Normalize() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Normalize() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVKVideoProviderMatch(t *testing.T) {
+	for link, want := range map[string]bool{
+		"https://vk.com/video-1_2":     true,
+		"https://www.vk.com/video-1_2": true,
+		"https://vk.cc/abc":            true,
+		"https://vk.company/video-1_2": false,
+		"https://rutube.ru/video/1/":   false,
+	} {
+		u, _ := url.Parse(link)
+		if got := NewVKVideoProvider().Match(u); got != want {
+			t.Errorf("Match(%s) = %t, want %t", link, got, want)
+		}
+	}
+}
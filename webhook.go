@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	tbot "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"net/http"
+)
+
+const (
+	WebhookPath = "/telegram/webhook"
+
+	webhookSecretHeader = "X-Telegram-Bot-Api-Secret-Token"
+)
+
+// listenForWebhook registers the webhook with Telegram and serves updates
+// on the default mux, which is exposed by the HTTP server started in main.
+func (b *bot) listenForWebhook() (tbot.UpdatesChannel, error) {
+	ch := make(chan tbot.Update, b.api.Buffer)
+
+	err := b.setWebhook(b.cfg.publicUrl + WebhookPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// The handler is only registered once the webhook is set, since nobody reads updates after falling back to polling
+	http.HandleFunc(WebhookPath, func(w http.ResponseWriter, r *http.Request) {
+		secret := r.Header.Get(webhookSecretHeader)
+		if subtle.ConstantTimeCompare([]byte(secret), []byte(b.cfg.webhookSecret)) != 1 {
+			log.Warnf("Rejected webhook request from %s: invalid secret token", r.RemoteAddr)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		update, err := b.api.HandleUpdate(r)
+		if err != nil {
+			log.WithError(err).Error("Could not parse webhook update")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		ch <- *update
+	})
+
+	log.Infof("Receiving updates using webhook %s", b.cfg.publicUrl+WebhookPath)
+
+	return ch, nil
+}
+
+// setWebhook is called through MakeRequest since tbot.WebhookConfig doesn't support the secret token.
+func (b *bot) setWebhook(url string) error {
+	params := make(tbot.Params)
+	params["url"] = url
+	params["secret_token"] = b.cfg.webhookSecret
+
+	resp, err := b.api.MakeRequest("setWebhook", params)
+	if err != nil {
+		return err
+	}
+	if !resp.Ok {
+		return errors.New(resp.Description)
+	}
+
+	return nil
+}
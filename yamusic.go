@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const (
+	yandexMusicTrack    = "track"
+	yandexMusicAlbum    = "album"
+	yandexMusicArtist   = "artist"
+	yandexMusicPlaylist = "playlist"
+)
+
+var (
+	yandexMusicTrackPattern    = regexp.MustCompile(`^(?:/album/\d+)?/track/(\d+)/?$`)
+	yandexMusicAlbumPattern    = regexp.MustCompile(`^/album/(\d+)/?$`)
+	yandexMusicArtistPattern   = regexp.MustCompile(`^/artist/(\d+)(?:/[a-z]+)?/?$`)
+	yandexMusicPlaylistPattern = regexp.MustCompile(`^/users/([^/]+)/playlists/(\d+)/?$`)
+	// yandexMusicShareablePlaylistPattern matches playlist links shared by uuid, e.g. `/playlists/lk.0a1b...`
+	yandexMusicShareablePlaylistPattern = regexp.MustCompile(`^/playlists/([\w.-]+)/?$`)
+)
+
+// yandexMusicProvider starts tracks, albums, artists and playlists of Yandex Music.
+// Unlike video providers, music is started by the `playMusic` station command.
+type yandexMusicProvider struct{}
+
+func NewYandexMusicProvider() *yandexMusicProvider {
+	return &yandexMusicProvider{}
+}
+
+func (p *yandexMusicProvider) Name() string {
+	return "Yandex Music"
+}
+
+func (p *yandexMusicProvider) Match(u *url.URL) bool {
+	return strings.HasPrefix(strings.TrimPrefix(u.Host, "www."), "music.yandex.")
+}
+
+func (p *yandexMusicProvider) Normalize(u *url.URL) (string, error) {
+	musicType, id := parseYandexMusicPath(u.Path)
+	if musicType == "" {
+		return "", NewBotError("This Yandex Music link doesn't look like a track, album, artist or playlist. Please, send me a valid one.")
+	}
+
+	switch musicType {
+	case yandexMusicTrack:
+		return fmt.Sprintf("https://music.yandex.ru/track/%s", id), nil
+	case yandexMusicAlbum:
+		return fmt.Sprintf("https://music.yandex.ru/album/%s", id), nil
+	case yandexMusicArtist:
+		return fmt.Sprintf("https://music.yandex.ru/artist/%s", id), nil
+	}
+
+	if owner, kind, ok := strings.Cut(id, ":"); ok {
+		return fmt.Sprintf("https://music.yandex.ru/users/%s/playlists/%s", owner, kind), nil
+	}
+
+	return fmt.Sprintf("https://music.yandex.ru/playlists/%s", id), nil
+}
+
+func (p *yandexMusicProvider) PlayerId() string {
+	return "music"
+}
+
+// ProviderItemId returns `<type>:<id>`, e.g. `track:123` or `playlist:owner:1000`.
+func (p *yandexMusicProvider) ProviderItemId(normalizedUrl string) string {
+	u, _ := url.Parse(normalizedUrl)
+	musicType, id := parseYandexMusicPath(u.Path)
+
+	return fmt.Sprintf("%s:%s", musicType, id)
+}
+
+func (p *yandexMusicProvider) StationCommand(normalizedUrl string) *stationCommand {
+	u, _ := url.Parse(normalizedUrl)
+	musicType, id := parseYandexMusicPath(u.Path)
+
+	return &stationCommand{Command: "playMusic", Id: id, Type: musicType}
+}
+
+// parseYandexMusicPath returns the type and the id of the music. Playlist id has `<owner>:<kind>` form
+// unless it is shared by uuid. Returns empty strings if the path is not recognized.
+func parseYandexMusicPath(p string) (string, string) {
+	p = strings.ToLower(p)
+
+	if m := yandexMusicTrackPattern.FindStringSubmatch(p); m != nil {
+		return yandexMusicTrack, m[1]
+	}
+	if m := yandexMusicAlbumPattern.FindStringSubmatch(p); m != nil {
+		return yandexMusicAlbum, m[1]
+	}
+	if m := yandexMusicArtistPattern.FindStringSubmatch(p); m != nil {
+		return yandexMusicArtist, m[1]
+	}
+	if m := yandexMusicPlaylistPattern.FindStringSubmatch(p); m != nil {
+		return yandexMusicPlaylist, fmt.Sprintf("%s:%s", m[1], m[2])
+	}
+	if m := yandexMusicShareablePlaylistPattern.FindStringSubmatch(p); m != nil {
+		return yandexMusicPlaylist, m[1]
+	}
+
+	return "", ""
+}
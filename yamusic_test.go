@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestYandexMusicProvider(t *testing.T) {
+	tests := []struct {
+		name       string
+		link       string
+		want       string
+		wantItemId string
+		wantType   string
+		wantId     string
+	}{
+		{"track", "https://music.yandex.ru/track/123", "https://music.yandex.ru/track/123", "track:123", "track", "123"},
+		{"track in album", "https://music.yandex.ru/album/45/track/123?utm_source=tg", "https://music.yandex.ru/track/123", "track:123", "track", "123"},
+		{"album", "https://music.yandex.com/album/45/", "https://music.yandex.ru/album/45", "album:45", "album", "45"},
+		{"artist", "https://music.yandex.ru/artist/67", "https://music.yandex.ru/artist/67", "artist:67", "artist", "67"},
+		{"artist tracks", "https://music.yandex.by/artist/67/tracks", "https://music.yandex.ru/artist/67", "artist:67", "artist", "67"},
+		{"user playlist", "https://music.yandex.ru/users/Some.Owner/playlists/1000", "https://music.yandex.ru/users/some.owner/playlists/1000", "playlist:some.owner:1000", "playlist", "some.owner:1000"},
+		{"shareable playlist", "https://music.yandex.ru/playlists/lk.0a1b2c3d-4e5f", "https://music.yandex.ru/playlists/lk.0a1b2c3d-4e5f", "playlist:lk.0a1b2c3d-4e5f", "playlist", "lk.0a1b2c3d-4e5f"},
+		{"www", "https://www.music.yandex.ru/track/123", "https://music.yandex.ru/track/123", "track:123", "track", "123"},
+	}
+
+	p := NewYandexMusicProvider()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.link)
+			if err != nil {
+				t.Fatalf("invalid test link: %v", err)
+			}
+			if !p.Match(u) {
+				t.Fatalf("link %s is not matched", tt.link)
+			}
+
+			got, err := p.Normalize(u)
+			if err != nil {
+				t.Fatalf("Normalize() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Normalize() = %s, want %s", got, tt.want)
+			}
+
+			if itemId := p.ProviderItemId(got); itemId != tt.wantItemId {
+				t.Errorf("ProviderItemId() = %s, want %s", itemId, tt.wantItemId)
+			}
+
+			c := p.StationCommand(got)
+			if c.Command != "playMusic" || c.Type != tt.wantType || c.Id != tt.wantId {
+				t.Errorf("StationCommand() = %s %s:%s, want playMusic %s:%s", c.Command, c.Type, c.Id, tt.wantType, tt.wantId)
+			}
+		})
+	}
+}
+
+func TestYandexMusicProviderRejectsUnknownLinks(t *testing.T) {
+	links := []string{
+		"https://music.yandex.ru/",
+		"https://music.yandex.ru/home",
+		"https://music.yandex.ru/track/abc",
+		"https://music.yandex.ru/album/45/track",
+		"https://music.yandex.ru/users/owner/playlists",
+		"https://music.yandex.ru/search?text=song",
+	}
+
+	p := NewYandexMusicProvider()
+	for _, link := range links {
+		u, _ := url.Parse(link)
+		if got, err := p.Normalize(u); err == nil {
+			t.Errorf("Normalize(%s) = %s, want error", link, got)
+		}
+	}
+}
+
+func TestYandexMusicProviderMatch(t *testing.T) {
+	for link, want := range map[string]bool{
+		"https://music.yandex.ru/track/1":  true,
+		"https://music.yandex.com/track/1": true,
+		"https://yandex.ru/video/1":        false,
+		"https://music.example.com/1":      false,
+	} {
+		u, _ := url.Parse(link)
+		if got := NewYandexMusicProvider().Match(u); got != want {
+			t.Errorf("Match(%s) = %t, want %t", link, got, want)
+		}
+	}
+}
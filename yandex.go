@@ -8,16 +8,21 @@ import (
 	"github.com/avast/retry-go"
 	"io"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 )
 
+const iotInfoCacheName = "iotuserinfo"
+
 type iotInfo struct {
 	Status     string      `json:"status"`
 	Message    string      `json:"message"`
 	Rooms      []room      `json:"rooms"`
 	Devices    []device    `json:"devices"`
 	Households []household `json:"households"`
+	Scenarios  []scenario  `json:"scenarios"`
+	Groups     []group     `json:"groups"`
 }
 
 type room struct {
@@ -32,12 +37,103 @@ type household struct {
 	Name string `json:"name"`
 }
 
+// group is a set of devices controlled together, e.g. all speakers in the living room
+type group struct {
+	Id      string   `json:"id"`
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	Devices []string `json:"devices"`
+}
+
+type scenario struct {
+	Id       string `json:"id"`
+	Name     string `json:"name"`
+	IsActive bool   `json:"is_active"`
+}
+
+// iotActionResponse is returned by IoT API action endpoints
+type iotActionResponse struct {
+	RequestId string `json:"request_id"`
+	Status    string `json:"status"`
+	Message   string `json:"message"`
+}
+
 type device struct {
-	Id         string     `json:"id"`
-	Name       string     `json:"name"`
-	Type       string     `json:"type"`
-	Room       string     `json:"room"`
-	QuasarInfo quasarInfo `json:"quasar_info"`
+	Id           string       `json:"id"`
+	Name         string       `json:"name"`
+	Type         string       `json:"type"`
+	Room         string       `json:"room"`
+	HouseholdId  string       `json:"household_id,omitempty"`
+	QuasarInfo   quasarInfo   `json:"quasar_info"`
+	Capabilities []capability `json:"capabilities,omitempty"`
+	Properties   []property   `json:"properties,omitempty"`
+}
+
+// capability is something the device can be told to do, e.g. turn on or change brightness
+type capability struct {
+	Type       string               `json:"type"`
+	Parameters capabilityParameters `json:"parameters"`
+	State      *capabilityState     `json:"state,omitempty"`
+}
+
+type capabilityParameters struct {
+	Instance string `json:"instance,omitempty"`
+	Unit     string `json:"unit,omitempty"`
+	// Range is set for range capabilities
+	Range *valueRange `json:"range,omitempty"`
+	// TemperatureK is set for color setting capabilities supporting white temperature
+	TemperatureK *valueRange `json:"temperature_k,omitempty"`
+}
+
+type valueRange struct {
+	Min       float64 `json:"min"`
+	Max       float64 `json:"max"`
+	Precision float64 `json:"precision,omitempty"`
+}
+
+type capabilityState struct {
+	Instance string `json:"instance"`
+	// Value is either bool, number or string depending on the capability
+	Value interface{} `json:"value"`
+}
+
+// property is something the device reports, e.g. temperature or humidity
+type property struct {
+	Type       string               `json:"type"`
+	Parameters capabilityParameters `json:"parameters"`
+	State      *capabilityState     `json:"state,omitempty"`
+}
+
+type deviceActionsRequest struct {
+	Devices []deviceActions `json:"devices"`
+}
+
+type deviceActions struct {
+	Id      string         `json:"id"`
+	Actions []deviceAction `json:"actions"`
+}
+
+type deviceAction struct {
+	Type  string          `json:"type"`
+	State capabilityState `json:"state"`
+}
+
+type deviceActionsResponse struct {
+	iotActionResponse
+	Devices []struct {
+		Id           string `json:"id"`
+		Capabilities []struct {
+			Type  string `json:"type"`
+			State struct {
+				Instance     string `json:"instance"`
+				ActionResult struct {
+					Status       string `json:"status"`
+					ErrorCode    string `json:"error_code"`
+					ErrorMessage string `json:"error_message"`
+				} `json:"action_result"`
+			} `json:"state"`
+		} `json:"capabilities"`
+	} `json:"devices"`
 }
 
 type quasarInfo struct {
@@ -46,8 +142,9 @@ type quasarInfo struct {
 }
 
 type mediaRequest struct {
-	Device  string              `json:"device"`
-	Message mediaRequestMessage `json:"msg"`
+	Device string `json:"device"`
+	// Message is either mediaRequestMessage or stationCommand
+	Message interface{} `json:"msg"`
 }
 
 type mediaRequestMessage struct {
@@ -55,18 +152,45 @@ type mediaRequestMessage struct {
 	ProviderItemId string `json:"provider_item_id"`
 }
 
+type stationCommand struct {
+	Command string `json:"command"`
+	Id      string `json:"id,omitempty"`
+	Type    string `json:"type,omitempty"`
+	// Volume is the level from 0 to 1 set by setVolume command
+	Volume *float64 `json:"volume,omitempty"`
+	// Position is the offset in seconds rewind command seeks to
+	Position *int `json:"position,omitempty"`
+	// Text is the request sendText command passes to Alice as if it was spoken
+	Text string `json:"text,omitempty"`
+}
+
+type oauthTokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	ExpiresIn        int    `json:"expires_in"`
+	RefreshToken     string `json:"refresh_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
 type YandexClient struct {
 	clientId      string
+	clientSecret  string
+	redirectUri   string
 	cacheProvider CacheProvider
 	httpClient    *http.Client
 }
 
-func NewYandexClient(clientId string, cacheProvider CacheProvider, httpClient *http.Client) *YandexClient {
+func NewYandexClient(clientId string, clientSecret string, redirectUri string, cacheProvider CacheProvider, httpClient *http.Client) *YandexClient {
 	if httpClient == nil {
 		log.Fatal("Http client must not be null")
 	}
 
-	return &YandexClient{clientId, cacheProvider, httpClient}
+	return &YandexClient{clientId, clientSecret, redirectUri, cacheProvider, httpClient}
+}
+
+// isCodeFlowEnabled reports whether authorization code flow can be used instead of the implicit one.
+func (y *YandexClient) isCodeFlowEnabled() bool {
+	return y.clientSecret != "" && y.redirectUri != ""
 }
 
 func (y *YandexClient) getTokens(rawToken string) (*token, *token, error) {
@@ -84,9 +208,114 @@ func (y *YandexClient) getTokens(rawToken string) (*token, *token, error) {
 	return oauthToken, csrfToken, nil
 }
 
-func (y *YandexClient) refreshTokens(s *session) error {
-	// TODO: Implement refresh of YandexOAuth token. It is valid for 1 year
+// exchangeAuthorizationCode returns OAuth, CSRF and refresh tokens issued for the authorization code.
+func (y *YandexClient) exchangeAuthorizationCode(code string) (*token, *token, string, error) {
+	if code == "" {
+		return nil, nil, "", errors.New("authorization code is required")
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+
+	tokenResp, err := y.requestOAuthToken(form)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	oauthToken := NewToken(tokenResp.AccessToken, &tokenResp.ExpiresIn)
+
+	csrfToken, err := y.getYandexCSRFToken(oauthToken.value)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	return oauthToken, csrfToken, tokenResp.RefreshToken, nil
+}
+
+// refreshOAuthToken issues new OAuth token using the refresh token. Returns the new token and refresh token.
+func (y *YandexClient) refreshOAuthToken(refreshToken string) (*token, string, error) {
+	if !y.isCodeFlowEnabled() {
+		return nil, "", errors.New("client secret is required to refresh OAuth token")
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", refreshToken)
+
+	tokenResp, err := y.requestOAuthToken(form)
+	if err != nil {
+		return nil, "", err
+	}
+
+	newRefreshToken := tokenResp.RefreshToken
+	if newRefreshToken == "" {
+		newRefreshToken = refreshToken
+	}
+
+	return NewToken(tokenResp.AccessToken, &tokenResp.ExpiresIn), newRefreshToken, nil
+}
+
+func (y *YandexClient) requestOAuthToken(form url.Values) (*oauthTokenResponse, error) {
+	form.Set("client_id", y.clientId)
+	form.Set("client_secret", y.clientSecret)
+
+	resp, err := y.httpClient.PostForm("https://oauth.yandex.com/token", form)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var tokenResp oauthTokenResponse
+	err = json.NewDecoder(resp.Body).Decode(&tokenResp)
+	if err != nil {
+		return nil, err
+	}
+	if tokenResp.Error != "" {
+		return nil, fmt.Errorf("%s: %s", tokenResp.Error, tokenResp.ErrorDescription)
+	}
+
+	return &tokenResp, nil
+}
+
+// revokeOAuthToken invalidates the OAuth token issued to the bot.
+func (y *YandexClient) revokeOAuthToken(oauthToken string) error {
+	if !y.isCodeFlowEnabled() {
+		return errors.New("client secret is required to revoke OAuth token")
+	}
+
+	form := url.Values{}
+	form.Set("access_token", oauthToken)
+	form.Set("client_id", y.clientId)
+	form.Set("client_secret", y.clientSecret)
+
+	resp, err := y.httpClient.PostForm("https://oauth.yandex.com/revoke_token", form)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var revokeResp struct {
+		Status           string `json:"status"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&revokeResp)
+	if err != nil {
+		return err
+	}
+	if revokeResp.Error != "" {
+		return fmt.Errorf("%s: %s", revokeResp.Error, revokeResp.ErrorDescription)
+	}
+	if revokeResp.Status != "ok" {
+		return fmt.Errorf("unexpected revocation status `%s`", revokeResp.Status)
+	}
+
+	return nil
+}
 
+// refreshTokens issues a new CSRF token. OAuth token is refreshed in background, see watchTokensExpiry.
+func (y *YandexClient) refreshTokens(s *session) error {
 	csrfToken, err := y.getYandexCSRFToken(s.oauthToken.value)
 	if err != nil {
 		return err
@@ -121,7 +350,7 @@ func (y *YandexClient) getYandexCSRFToken(oauthToken string) (*token, error) {
 }
 
 func (y *YandexClient) getYandexSmartHomeInfo(s *session) (*iotInfo, error) {
-	cacheKey := fmt.Sprintf("%d_%s", s.chatId, "iotuserinfo")
+	cacheKey := iotInfoCacheKey(s)
 	val, found := y.cacheProvider.TryGet(cacheKey)
 	if found {
 		return val.(*iotInfo), nil
@@ -157,6 +386,35 @@ func (y *YandexClient) getYandexSmartHomeInfo(s *session) (*iotInfo, error) {
 	return dataResp, nil
 }
 
+// invalidateSmartHomeInfo makes the next getYandexSmartHomeInfo call return fresh device states.
+func (y *YandexClient) invalidateSmartHomeInfo(s *session) {
+	y.cacheProvider.Delete(iotInfoCacheKey(s))
+}
+
+// iotInfoCacheKey returns the smart home info cache key of the active account. Members of group chats have their own accounts.
+func iotInfoCacheKey(s *session) string {
+	return chatCacheKey(s.chatId, fmt.Sprintf("%s_%d_%s", iotInfoCacheName, s.userId, s.activeAccountName()))
+}
+
+// getSmartHomeDevices returns all devices except for yandex stations, e.g. lights and sockets.
+func (y *YandexClient) getSmartHomeDevices(s *session) ([]device, error) {
+	iotInfo, err := y.getYandexSmartHomeInfo(s)
+	if err != nil {
+		return nil, NewBotError("Could not get list of smart home devices. Please, try again later.")
+	}
+
+	devices := make([]device, 0)
+	for _, d := range iotInfo.Devices {
+		if strings.Contains(d.Type, YandexStationTypeSubstr) {
+			continue
+		}
+
+		devices = append(devices, d)
+	}
+
+	return devices, nil
+}
+
 func (y *YandexClient) getYandexStations(s *session) ([]device, error) {
 	iotInfo, err := y.getYandexSmartHomeInfo(s)
 	if err != nil {
@@ -175,11 +433,102 @@ func (y *YandexClient) getYandexStations(s *session) ([]device, error) {
 	return stations, nil
 }
 
-func (y *YandexClient) getOAuthUrl() string {
-	return fmt.Sprintf("https://oauth.yandex.com/authorize?response_type=token&client_id=%v", y.clientId)
+// runScenario starts the Smart Home scenario. Returned response holds the error message if Yandex has refused it.
+func (y *YandexClient) runScenario(s *session, scenarioId string) (*iotActionResponse, error) {
+	var dataResp = &iotActionResponse{}
+	err := y.postIotAction(s, fmt.Sprintf("scenarios/%s/actions", url.PathEscape(scenarioId)), nil, dataResp)
+	if err != nil {
+		return nil, err
+	}
+
+	return dataResp, nil
+}
+
+// runDeviceAction changes the state of the device capability. Smart home info is invalidated, so the new state is shown afterwards.
+func (y *YandexClient) runDeviceAction(s *session, deviceId string, action deviceAction) (*deviceActionsResponse, error) {
+	body := &deviceActionsRequest{
+		Devices: []deviceActions{{Id: deviceId, Actions: []deviceAction{action}}},
+	}
+
+	var dataResp = &deviceActionsResponse{}
+	err := y.postIotAction(s, "devices/actions", body, dataResp)
+	if err != nil {
+		return nil, err
+	}
+	y.invalidateSmartHomeInfo(s)
+
+	return dataResp, nil
+}
+
+// postIotAction posts the body to IoT API and decodes the response into v.
+func (y *YandexClient) postIotAction(s *session, path string, body interface{}, v interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewBuffer(data)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "https://api.iot.yandex.net/v1.0/"+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Authorization", fmt.Sprintf("OAuth %s", s.oauthToken.value))
+	if body != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+
+	resp, err := y.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	err = json.NewDecoder(resp.Body).Decode(v)
+	if err != nil {
+		return fmt.Errorf("could not decode response with status code %d: %w", resp.StatusCode, err)
+	}
+
+	return nil
+}
+
+// getOAuthUrl returns authorization url. State is only used by authorization code flow.
+func (y *YandexClient) getOAuthUrl(state string) string {
+	if !y.isCodeFlowEnabled() {
+		return fmt.Sprintf("https://oauth.yandex.com/authorize?response_type=token&client_id=%v", y.clientId)
+	}
+
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", y.clientId)
+	q.Set("redirect_uri", y.redirectUri)
+	q.Set("state", state)
+
+	return "https://oauth.yandex.com/authorize?" + q.Encode()
+}
+
+func (y *YandexClient) playMedia(s *session, d *device, m *media) error {
+	var msg interface{} = mediaRequestMessage{
+		PlayerId:       m.playerId,
+		ProviderItemId: m.providerItemId,
+	}
+	if m.command != nil {
+		msg = m.command
+	}
+
+	return y.sendToStation(s, d, msg, NewBotError("Could not share the medial link with Alice. Please, try again later."))
 }
 
-func (y *YandexClient) playMedia(s *session, d *device, url string) error {
+// sendCommand sends a playback control command, e.g. pause or volume change, to the station.
+func (y *YandexClient) sendCommand(s *session, d *device, c *stationCommand) error {
+	return y.sendToStation(s, d, c, NewBotError("The station has refused the command. Please, try again later."))
+}
+
+// sendToStation delivers the message to the device or to the default one if d is nil.
+// refused is returned if the station has responded with an error.
+func (y *YandexClient) sendToStation(s *session, d *device, msg interface{}, refused *botError) error {
 	var dId string
 	if s.defaultDevice != nil {
 		dId = s.defaultDevice.QuasarInfo.Id
@@ -189,29 +538,26 @@ func (y *YandexClient) playMedia(s *session, d *device, url string) error {
 	}
 
 	if dId == "" {
-		return NewBotError("Cannot play media. No device has been selected.")
+		return NewBotError("No device has been selected.")
 	}
 
-	// TODO: refactor to be dynamic
 	mReq := &mediaRequest{
-		Device: dId,
-		Message: mediaRequestMessage{
-			PlayerId:       "youtube",
-			ProviderItemId: url,
-		},
+		Device:  dId,
+		Message: msg,
 	}
 
 	jsonData, _ := json.Marshal(mReq)
-	req, err := http.NewRequest(http.MethodPost, "https://yandex.ru/video/station", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return err
-	}
-
-	req.Header.Add("Authorization", fmt.Sprintf("OAuth %s", s.oauthToken.value))
-	req.Header.Add("x-csrf-token", s.csrfToken.value)
 
-	err = retry.Do(
+	err := retry.Do(
 		func() error {
+			// Request body is consumed by every attempt, so the request is created anew
+			req, err := http.NewRequest(http.MethodPost, "https://yandex.ru/video/station", bytes.NewBuffer(jsonData))
+			if err != nil {
+				return err
+			}
+			req.Header.Add("Authorization", fmt.Sprintf("OAuth %s", s.oauthToken.value))
+			req.Header.Add("x-csrf-token", s.csrfToken.value)
+
 			resp, err := y.httpClient.Do(req)
 			if err != nil {
 				return err
@@ -230,12 +576,14 @@ func (y *YandexClient) playMedia(s *session, d *device, url string) error {
 			}
 
 			if b["status"].(string) == "error" {
-				return NewBotError("Could not share the medial link with Alice. Please, try again later.")
+				return refused
 			}
 
 			return nil
 		},
 		retry.Attempts(5),
+		// Callers tell refusals apart from other failures by botError, which retry.Error would hide
+		retry.LastErrorOnly(true),
 		retry.RetryIf(func(err error) bool {
 			if _, ok := err.(*botError); ok {
 				return false
@@ -244,7 +592,7 @@ func (y *YandexClient) playMedia(s *session, d *device, url string) error {
 			return true
 		}),
 		retry.OnRetry(func(n uint, err error) {
-			log.WithError(err).Errorf("could not share media. Attemp: #%d", n)
+			log.WithError(err).Errorf("could not send message to station. Attemp: #%d", n)
 		}),
 	)
 
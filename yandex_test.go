@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSendToStation(t *testing.T) {
+	refused := NewBotError("refused")
+
+	tests := []struct {
+		name string
+		body string
+		send func(yc *YandexClient, s *session, d *device) error
+		// wantErr is nil if the message must be delivered
+		wantErr func(err error) bool
+	}{
+		{
+			name: "delivered",
+			body: `{"status":"ok"}`,
+			send: func(yc *YandexClient, s *session, d *device) error {
+				return yc.sendToStation(s, d, mediaRequestMessage{}, refused)
+			},
+		},
+		{
+			name: "refused",
+			body: `{"status":"error"}`,
+			send: func(yc *YandexClient, s *session, d *device) error {
+				return yc.sendToStation(s, d, mediaRequestMessage{}, refused)
+			},
+			// The refusal must reach the caller unwrapped, see playYouTubePlaylist
+			wantErr: func(err error) bool { return err == refused },
+		},
+		{
+			name: "command refused",
+			body: `{"status":"error"}`,
+			send: func(yc *YandexClient, s *session, d *device) error {
+				return yc.sendCommand(s, d, &stationCommand{Command: "stop"})
+			},
+			wantErr: func(err error) bool {
+				_, ok := err.(*botError)
+				return ok
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int32
+			yc := NewYandexClient("", "", "", NewInMemoryCacheProvider(), &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				atomic.AddInt32(&requests, 1)
+				return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(tt.body)), Header: make(http.Header), Request: r}, nil
+			})})
+			s := NewSession(sessionKey{1, 1}, NewToken("token", nil), NewToken("csrf", nil), "")
+			d := &device{Id: "station", QuasarInfo: quasarInfo{Id: "quasar"}}
+
+			err := tt.send(yc, s, d)
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("error = %v, want the message delivered", err)
+			}
+			if tt.wantErr != nil && !tt.wantErr(err) {
+				t.Errorf("error = %v, want the refusal", err)
+			}
+			// Neither delivered nor refused messages are sent again
+			if n := atomic.LoadInt32(&requests); n != 1 {
+				t.Errorf("message has been sent %d times, want once", n)
+			}
+		})
+	}
+}
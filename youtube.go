@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	youTubeVideoIdPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{11}$`)
+	// timestampPattern matches `1h2m3s`, `1m30s`, `90s` and `90` timestamps
+	timestampPattern = regexp.MustCompile(`^(?:(\d+)h)?(?:(\d+)m)?(?:(\d+)s?)?$`)
+)
+
+// maxTimestamp is the longest timestamp in seconds. Longer ones are rejected, so the offset never overflows
+const maxTimestamp = math.MaxInt32
+
+// youTubeVideoPathPrefixes are the paths followed by the video id
+var youTubeVideoPathPrefixes = []string{"/shorts/", "/live/", "/embed/", "/v/", "/e/"}
+
+// youTubeNonVideoPathPrefixes are the paths of channels, search results and feeds
+var youTubeNonVideoPathPrefixes = []string{"/channel/", "/c/", "/user/", "/@", "/results", "/feed", "/hashtag/"}
+
+type youTubeProvider struct{}
+
+func NewYouTubeProvider() *youTubeProvider {
+	return &youTubeProvider{}
+}
+
+func (p *youTubeProvider) Name() string {
+	return "YouTube"
+}
+
+func (p *youTubeProvider) Match(u *url.URL) bool {
+	host := strings.TrimPrefix(u.Host, "www.")
+	return host == "youtu.be" ||
+		host == "youtube.com" ||
+		strings.HasSuffix(host, ".youtube.com") ||
+		host == "youtube-nocookie.com"
+}
+
+// Normalize converts any kind of video link to `https://www.youtube.com/watch?v=<id>`
+// keeping the start timestamp and the playlist only.
+func (p *youTubeProvider) Normalize(u *url.URL) (string, error) {
+	yu, err := parseYouTubeUrl(u)
+	if err != nil {
+		return "", err
+	}
+
+	return yu.String(), nil
+}
+
+func (p *youTubeProvider) PlayerId() string {
+	return "youtube"
+}
+
+func (p *youTubeProvider) ProviderItemId(normalizedUrl string) string {
+	return normalizedUrl
+}
+
+type youTubeUrl struct {
+	videoId    string
+	playlistId string
+	// start is the playback start offset in seconds
+	start int
+}
+
+func (yu *youTubeUrl) String() string {
+	if yu.videoId == "" {
+		return fmt.Sprintf("https://www.youtube.com/playlist?list=%s", url.QueryEscape(yu.playlistId))
+	}
+
+	// Parameters are appended manually to keep the video id first
+	s := fmt.Sprintf("https://www.youtube.com/watch?v=%s", yu.videoId)
+	if yu.playlistId != "" {
+		s += "&list=" + url.QueryEscape(yu.playlistId)
+	}
+	if yu.start > 0 {
+		s += "&t=" + strconv.Itoa(yu.start)
+	}
+
+	return s
+}
+
+func parseYouTubeUrl(u *url.URL) (*youTubeUrl, error) {
+	q := u.Query()
+	yu := &youTubeUrl{
+		playlistId: q.Get("list"),
+		start:      parseYouTubeTimestamp(q, u.Fragment),
+	}
+
+	for _, prefix := range youTubeNonVideoPathPrefixes {
+		if strings.HasPrefix(u.Path, prefix) {
+			return nil, NewBotError("This YouTube link points to a channel or a search page. Please, send me a link to a video or a playlist.")
+		}
+	}
+
+	switch {
+	case strings.TrimPrefix(u.Host, "www.") == "youtu.be":
+		yu.videoId = strings.Trim(u.Path, "/")
+	case u.Path == "/watch":
+		yu.videoId = q.Get("v")
+	case u.Path == "/playlist" || u.Path == "/playlist/":
+		if yu.playlistId == "" {
+			return nil, NewBotError("This YouTube playlist link is missing the playlist id. Please, send me a valid one.")
+		}
+		return yu, nil
+	default:
+		for _, prefix := range youTubeVideoPathPrefixes {
+			if strings.HasPrefix(u.Path, prefix) {
+				yu.videoId = strings.SplitN(strings.TrimPrefix(u.Path, prefix), "/", 2)[0]
+				break
+			}
+		}
+	}
+
+	if !youTubeVideoIdPattern.MatchString(yu.videoId) {
+		return nil, NewBotError("I could not find a YouTube video in this link. Please, send me a link to a video or a playlist.")
+	}
+
+	return yu, nil
+}
+
+// parseYouTubeTimestamp returns the start offset in seconds from `t` or `start` query parameters or `t` fragment.
+func parseYouTubeTimestamp(q url.Values, fragment string) int {
+	v := q.Get("t")
+	if v == "" {
+		v = q.Get("start")
+	}
+	if v == "" && strings.HasPrefix(fragment, "t=") {
+		v = strings.TrimPrefix(fragment, "t=")
+	}
+
+	seconds, _ := parseTimestamp(v)
+	return seconds
+}
+
+// parseTimestamp returns the number of seconds in `1h2m3s`, `1m30s`, `90s` and `90` timestamps.
+func parseTimestamp(v string) (int, bool) {
+	m := timestampPattern.FindStringSubmatch(v)
+	if v == "" || m == nil {
+		return 0, false
+	}
+
+	var seconds int
+	for i, multiplier := range []int{3600, 60, 1} {
+		if m[i+1] == "" {
+			continue
+		}
+
+		n, err := strconv.Atoi(m[i+1])
+		if err != nil || n > (maxTimestamp-seconds)/multiplier {
+			return 0, false
+		}
+		seconds += n * multiplier
+	}
+
+	return seconds, true
+}
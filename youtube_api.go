@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const youTubePlaylistItemsLimit = 200
+
+// youTubeDurationPattern matches ISO 8601 durations returned by YouTube Data API, e.g. `PT1H2M3S`
+var youTubeDurationPattern = regexp.MustCompile(`^P(?:(\d+)D)?T?(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?$`)
+
+type youTubeVideo struct {
+	id       string
+	title    string
+	duration time.Duration
+}
+
+type youTubePlaylistItemsResponse struct {
+	NextPageToken string `json:"nextPageToken"`
+	Items         []struct {
+		Snippet struct {
+			Title string `json:"title"`
+		} `json:"snippet"`
+		ContentDetails struct {
+			VideoId string `json:"videoId"`
+		} `json:"contentDetails"`
+	} `json:"items"`
+	Error *youTubeApiError `json:"error"`
+}
+
+type youTubeVideosResponse struct {
+	Items []struct {
+		Id             string `json:"id"`
+		ContentDetails struct {
+			Duration string `json:"duration"`
+		} `json:"contentDetails"`
+	} `json:"items"`
+	Error *youTubeApiError `json:"error"`
+}
+
+type youTubeApiError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// YouTubeClient fetches playlist items using YouTube Data API.
+type YouTubeClient struct {
+	apiKey     string
+	httpClient *http.Client
+}
+
+func NewYouTubeClient(apiKey string, httpClient *http.Client) *YouTubeClient {
+	if httpClient == nil {
+		log.Fatal("Http client must not be null")
+	}
+
+	return &YouTubeClient{apiKey, httpClient}
+}
+
+// getPlaylistVideos returns up to youTubePlaylistItemsLimit videos of the playlist with their durations.
+func (c *YouTubeClient) getPlaylistVideos(playlistId string) ([]youTubeVideo, error) {
+	videos := make([]youTubeVideo, 0)
+
+	pageToken := ""
+	for len(videos) < youTubePlaylistItemsLimit {
+		q := url.Values{}
+		q.Set("part", "snippet,contentDetails")
+		q.Set("maxResults", "50")
+		q.Set("playlistId", playlistId)
+		if pageToken != "" {
+			q.Set("pageToken", pageToken)
+		}
+
+		var resp youTubePlaylistItemsResponse
+		if err := c.get("playlistItems", q, &resp); err != nil {
+			return nil, err
+		}
+		if resp.Error != nil {
+			return nil, fmt.Errorf("youtube api error %d: %s", resp.Error.Code, resp.Error.Message)
+		}
+
+		for _, item := range resp.Items {
+			videos = append(videos, youTubeVideo{id: item.ContentDetails.VideoId, title: item.Snippet.Title})
+		}
+
+		pageToken = resp.NextPageToken
+		if pageToken == "" {
+			break
+		}
+	}
+
+	if len(videos) > youTubePlaylistItemsLimit {
+		videos = videos[:youTubePlaylistItemsLimit]
+	}
+
+	return videos, c.fillDurations(videos)
+}
+
+func (c *YouTubeClient) fillDurations(videos []youTubeVideo) error {
+	for from := 0; from < len(videos); from += 50 {
+		to := from + 50
+		if to > len(videos) {
+			to = len(videos)
+		}
+
+		ids := make([]string, 0, to-from)
+		for _, v := range videos[from:to] {
+			ids = append(ids, v.id)
+		}
+
+		q := url.Values{}
+		q.Set("part", "contentDetails")
+		q.Set("id", strings.Join(ids, ","))
+
+		var resp youTubeVideosResponse
+		if err := c.get("videos", q, &resp); err != nil {
+			return err
+		}
+		if resp.Error != nil {
+			return fmt.Errorf("youtube api error %d: %s", resp.Error.Code, resp.Error.Message)
+		}
+
+		durations := make(map[string]time.Duration)
+		for _, item := range resp.Items {
+			durations[item.Id] = parseYouTubeDuration(item.ContentDetails.Duration)
+		}
+		for i := from; i < to; i++ {
+			videos[i].duration = durations[videos[i].id]
+		}
+	}
+
+	return nil
+}
+
+func (c *YouTubeClient) get(resource string, q url.Values, v interface{}) error {
+	q.Set("key", c.apiKey)
+
+	resp, err := c.httpClient.Get(fmt.Sprintf("https://www.googleapis.com/youtube/v3/%s?%s", resource, q.Encode()))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// parseYouTubeDuration returns zero duration if the value cannot be parsed.
+func parseYouTubeDuration(v string) time.Duration {
+	m := youTubeDurationPattern.FindStringSubmatch(v)
+	if m == nil {
+		return 0
+	}
+
+	var d time.Duration
+	for i, unit := range []time.Duration{24 * time.Hour, time.Hour, time.Minute, time.Second} {
+		if m[i+1] == "" {
+			continue
+		}
+
+		n, _ := strconv.Atoi(m[i+1])
+		d += time.Duration(n) * unit
+	}
+
+	return d
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestParseYouTubeUrl(t *testing.T) {
+	tests := []struct {
+		name string
+		link string
+		want string
+	}{
+		{"watch", "https://www.youtube.com/watch?v=dQw4w9WgXcQ", "https://www.youtube.com/watch?v=dQw4w9WgXcQ"},
+		{"short link", "https://youtu.be/dQw4w9WgXcQ", "https://www.youtube.com/watch?v=dQw4w9WgXcQ"},
+		{"shorts", "https://youtube.com/shorts/dQw4w9WgXcQ?feature=share", "https://www.youtube.com/watch?v=dQw4w9WgXcQ"},
+		{"live", "https://www.youtube.com/live/dQw4w9WgXcQ?si=abc", "https://www.youtube.com/watch?v=dQw4w9WgXcQ"},
+		{"embed", "https://www.youtube.com/embed/dQw4w9WgXcQ?autoplay=1", "https://www.youtube.com/watch?v=dQw4w9WgXcQ"},
+		{"music", "https://music.youtube.com/watch?v=dQw4w9WgXcQ&feature=share", "https://www.youtube.com/watch?v=dQw4w9WgXcQ"},
+		{"mobile", "https://m.youtube.com/watch?v=dQw4w9WgXcQ", "https://www.youtube.com/watch?v=dQw4w9WgXcQ"},
+		{"nocookie", "https://www.youtube-nocookie.com/embed/dQw4w9WgXcQ", "https://www.youtube.com/watch?v=dQw4w9WgXcQ"},
+		{"old embed", "https://www.youtube.com/v/dQw4w9WgXcQ", "https://www.youtube.com/watch?v=dQw4w9WgXcQ"},
+		{"minutes and seconds", "https://www.youtube.com/watch?v=dQw4w9WgXcQ&t=1m30s", "https://www.youtube.com/watch?v=dQw4w9WgXcQ&t=90"},
+		{"hours", "https://youtu.be/dQw4w9WgXcQ?t=1h2m3s", "https://www.youtube.com/watch?v=dQw4w9WgXcQ&t=3723"},
+		{"seconds", "https://youtu.be/dQw4w9WgXcQ?t=42", "https://www.youtube.com/watch?v=dQw4w9WgXcQ&t=42"},
+		{"start", "https://www.youtube.com/embed/dQw4w9WgXcQ?start=75", "https://www.youtube.com/watch?v=dQw4w9WgXcQ&t=75"},
+		{"fragment", "https://www.youtube.com/watch?v=dQw4w9WgXcQ#t=2m", "https://www.youtube.com/watch?v=dQw4w9WgXcQ&t=120"},
+		{"invalid timestamp", "https://www.youtube.com/watch?v=dQw4w9WgXcQ&t=soon", "https://www.youtube.com/watch?v=dQw4w9WgXcQ"},
+		{"tracking parameters", "https://www.youtube.com/watch?v=dQw4w9WgXcQ&si=xyz&feature=youtu.be&pp=abc&utm_source=tg", "https://www.youtube.com/watch?v=dQw4w9WgXcQ"},
+		{"short link tracking parameters", "https://youtu.be/dQw4w9WgXcQ?si=xyz&t=10", "https://www.youtube.com/watch?v=dQw4w9WgXcQ&t=10"},
+		{"video in playlist", "https://www.youtube.com/watch?v=dQw4w9WgXcQ&list=PL123&index=2", "https://www.youtube.com/watch?v=dQw4w9WgXcQ&list=PL123"},
+		{"playlist", "https://www.youtube.com/playlist?list=PL123&si=xyz", "https://www.youtube.com/playlist?list=PL123"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.link)
+			if err != nil {
+				t.Fatalf("invalid test link: %v", err)
+			}
+
+			p := NewYouTubeProvider()
+			if !p.Match(u) {
+				t.Fatalf("link %s is not matched", tt.link)
+			}
+
+			got, err := p.Normalize(u)
+			if err != nil {
+				t.Fatalf("Normalize() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Normalize() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseYouTubeUrlRejectsNonVideoLinks(t *testing.T) {
+	tests := []struct {
+		name string
+		link string
+	}{
+		{"channel", "https://www.youtube.com/channel/UC38IQsAvIsxxjztdMZQtwHA"},
+		{"custom channel", "https://www.youtube.com/c/SomeChannel"},
+		{"user", "https://www.youtube.com/user/someone"},
+		{"handle", "https://www.youtube.com/@someone"},
+		{"search", "https://www.youtube.com/results?search_query=never+gonna"},
+		{"feed", "https://www.youtube.com/feed/subscriptions"},
+		{"hashtag", "https://www.youtube.com/hashtag/music"},
+		{"home", "https://www.youtube.com/"},
+		{"playlist without id", "https://www.youtube.com/playlist"},
+		{"malformed video id", "https://youtu.be/short"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.link)
+			if err != nil {
+				t.Fatalf("invalid test link: %v", err)
+			}
+
+			yu, err := parseYouTubeUrl(u)
+			if err == nil {
+				t.Fatalf("parseYouTubeUrl() = %s, want error", yu)
+			}
+			if _, ok := err.(*botError); !ok {
+				t.Errorf("parseYouTubeUrl() error = %v, want botError", err)
+			}
+		})
+	}
+}
+
+func TestParseYouTubeTimestamp(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		fragment string
+		want     int
+	}{
+		{"none", "", "", 0},
+		{"seconds", "t=90", "", 90},
+		{"seconds suffix", "t=90s", "", 90},
+		{"minutes and seconds", "t=1m30s", "", 90},
+		{"minutes", "t=5m", "", 300},
+		{"hours", "t=1h", "", 3600},
+		{"hours minutes and seconds", "t=2h3m4s", "", 7384},
+		{"start", "start=45", "", 45},
+		{"t over start", "t=10&start=45", "", 10},
+		{"fragment", "", "t=1m5s", 65},
+		{"query over fragment", "t=10", "t=20", 10},
+		{"other fragment", "", "comments", 0},
+		{"invalid", "t=1x", "", 0},
+		{"overflowing seconds", "t=99999999999999999999", "", 0},
+		{"overflowing hours", "t=9999999999999999h", "", 0},
+		{"overflowing minutes", "t=3000000000m", "", 0},
+		{"longest", "t=2147483647", "", 2147483647},
+		{"overflowing sum", "t=596523h14m8s", "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatalf("invalid test query: %v", err)
+			}
+
+			if got := parseYouTubeTimestamp(q, tt.fragment); got != tt.want {
+				t.Errorf("parseYouTubeTimestamp() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}